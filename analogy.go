@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+// 3CosMul epsilon from Levy and Goldberg (2014).
+const cosMulEpsilon = 0.001
+
+type analogyQuestion struct {
+	section    string
+	a, b, c, d string
+}
+
+type analogyDataset struct {
+	name      string
+	sections  []string
+	questions []analogyQuestion
+}
+
+// readAnalogyDataset reads "a b c d" questions (a is to b as c is to d). Lines
+// starting with ":" begin a new section, as in the Google analogy dataset.
+// Questions in files without sections, such as MSR, belong to a single section
+// named after the file. Words are kept as the tokenizer would have added them
+// to the vocab.
+func readAnalogyDataset(path string) analogyDataset {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	ds := analogyDataset{name: datasetName(path)}
+	section := ds.name
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, ":") {
+			section = strings.TrimSpace(line[1:])
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 4 {
+			continue
+		}
+		if len(ds.sections) == 0 || ds.sections[len(ds.sections)-1] != section {
+			ds.sections = append(ds.sections, section)
+		}
+		ds.questions = append(ds.questions, analogyQuestion{section, vocabForm(parts[0]), vocabForm(parts[1]), vocabForm(parts[2]), vocabForm(parts[3])})
+	}
+	check(s.Err())
+	return ds
+}
+
+// analogyVectors are the unit-length vectors of the words searched when
+// answering analogies.
+type analogyVectors struct {
+	m     []real
+	n     int
+	dim   int
+	index map[string]int
+}
+
+// newAnalogyVectors copies and normalizes the vectors of the first topN words
+// (all if topN is 0). row(i) returns the vector of words[i].
+func newAnalogyVectors(words []string, dim int, row func(i int) []real) *analogyVectors {
+	n := len(words)
+	if topN > 0 && topN < n {
+		n = topN
+	}
+	av := &analogyVectors{make([]real, n*dim), n, dim, make(map[string]int, n)}
+	for i := 0; i < n; i++ {
+		av.index[words[i]] = i
+		copy(av.m[i*dim:(i+1)*dim], row(i))
+	}
+	normalizeRows(av.m, dim)
+	return av
+}
+
+// answer returns the index of the answers given by 3CosAdd and 3CosMul.
+func (av *analogyVectors) answer(ia, ib, ic int) (cosAdd, cosMul int) {
+	a := av.m[ia*av.dim : (ia+1)*av.dim]
+	b := av.m[ib*av.dim : (ib+1)*av.dim]
+	c := av.m[ic*av.dim : (ic+1)*av.dim]
+	cosAdd, cosMul = -1, -1
+	var bestAdd, bestMul real
+	for j := 0; j < av.n; j++ {
+		if j == ia || j == ib || j == ic {
+			continue
+		}
+		v := av.m[j*av.dim : (j+1)*av.dim]
+		var sa, sb, sc real
+		for k, x := range v {
+			sa += a[k] * x
+			sb += b[k] * x
+			sc += c[k] * x
+		}
+		add := sb - sa + sc
+		mul := ((sb + 1) / 2) * ((sc + 1) / 2) / ((sa+1)/2 + cosMulEpsilon)
+		if cosAdd < 0 || add > bestAdd {
+			cosAdd, bestAdd = j, add
+		}
+		if cosMul < 0 || mul > bestMul {
+			cosMul, bestMul = j, mul
+		}
+	}
+	return
+}
+
+type analogyResult struct {
+	Dataset   string    `json:"dataset"`
+	Section   string    `json:"section"`
+	Questions int       `json:"questions"`
+	Covered   int       `json:"covered"`
+	Coverage  real      `json:"coverage"`
+	CosAdd    evalScore `json:"3cosadd"`
+	CosMul    evalScore `json:"3cosmul"`
+}
+
+type analogyCounts struct {
+	questions, covered, cosAdd, cosMul int
+}
+
+func (c analogyCounts) result(dataset, section string) analogyResult {
+	r := analogyResult{Dataset: dataset, Section: section, Questions: c.questions, Covered: c.covered}
+	if c.questions > 0 {
+		r.Coverage = real(c.covered) / real(c.questions)
+	}
+	r.CosAdd = evalScore(real(c.cosAdd) / real(c.covered))
+	r.CosMul = evalScore(real(c.cosMul) / real(c.covered))
+	return r
+}
+
+// evalAnalogy answers every question whose four words are in av, splitting
+// questions across numThreads goroutines. Results are given for each section
+// followed by the Google semantic and syntactic ("gram" sections) totals when
+// the dataset has sections, and the overall total.
+func evalAnalogy(ds analogyDataset, av *analogyVectors) []analogyResult {
+	correctAdd := make([]bool, len(ds.questions))
+	correctMul := make([]bool, len(ds.questions))
+	covered := make([]bool, len(ds.questions))
+	var wg sync.WaitGroup
+	for threadID := 0; threadID < numThreads; threadID++ {
+		wg.Add(1)
+		go func(threadID int) {
+			defer wg.Done()
+			for i := threadID; i < len(ds.questions); i += numThreads {
+				q := ds.questions[i]
+				ia, okA := av.index[q.a]
+				ib, okB := av.index[q.b]
+				ic, okC := av.index[q.c]
+				id, okD := av.index[q.d]
+				if !okA || !okB || !okC || !okD {
+					continue
+				}
+				covered[i] = true
+				cosAdd, cosMul := av.answer(ia, ib, ic)
+				correctAdd[i] = cosAdd == id
+				correctMul[i] = cosMul == id
+			}
+		}(threadID)
+	}
+	wg.Wait()
+
+	bySection := make(map[string]*analogyCounts)
+	var semantic, syntactic, total analogyCounts
+	for _, section := range ds.sections {
+		bySection[section] = &analogyCounts{}
+	}
+	for i, q := range ds.questions {
+		counts := []*analogyCounts{bySection[q.section], &total, &semantic}
+		if strings.HasPrefix(q.section, "gram") {
+			counts[2] = &syntactic
+		}
+		for _, c := range counts {
+			c.questions++
+			if covered[i] {
+				c.covered++
+			}
+			if correctAdd[i] {
+				c.cosAdd++
+			}
+			if correctMul[i] {
+				c.cosMul++
+			}
+		}
+	}
+
+	var results []analogyResult
+	if len(ds.sections) > 1 {
+		for _, section := range ds.sections {
+			results = append(results, bySection[section].result(ds.name, section))
+		}
+		if syntactic.questions > 0 {
+			results = append(results, semantic.result(ds.name, "semantic"), syntactic.result(ds.name, "syntactic"))
+		}
+	}
+	return append(results, total.result(ds.name, "total"))
+}
+
+func evalAnalogies() {
+	if len(evalDatasetPaths) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -data is a required argument")
+	}
+	if len(evalVectorsPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -vectors is a required argument")
+	}
+	logln(infoLogLevel, "loading vectors")
+	d, err := embedding.LoadText(evalVectorsPath)
+	check(err)
+	av := newAnalogyVectors(d.Vocab(), d.Dim(), d.Row)
+	logln(infoLogLevel, "searching %d words", av.n)
+	var results []analogyResult
+	for _, path := range evalDatasetPaths {
+		logln(infoLogLevel, "evaluating %s", path)
+		results = append(results, evalAnalogy(readAnalogyDataset(path), av)...)
+	}
+	writeEvalResults(results, func(out *bufio.Writer) {
+		fmt.Fprintf(out, "%-20s %-30s %9s %8s %9s %8s %8s\n", "dataset", "section", "questions", "covered", "coverage", "3cosadd", "3cosmul")
+		for _, r := range results {
+			fmt.Fprintf(out, "%-20s %-30s %9d %8d %8.1f%% %7.1f%% %7.1f%%\n", r.Dataset, r.Section, r.Questions, r.Covered, r.Coverage*100, r.CosAdd*100, r.CosMul*100)
+		}
+	})
+}
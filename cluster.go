@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+// clusterWords runs spherical k-means over the normalized vectors given by
+// -vectors (the -topn most frequent if given), writing "word cluster" lines
+// to stdout, followed by the cluster's bit string when -bitcodes is given.
+// Centroids are saved to -centroids in the text vector format.
+func clusterWords() {
+	if len(evalVectorsPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -vectors is a required argument")
+	}
+	logln(infoLogLevel, "loading vectors")
+	vecs, err := embedding.LoadText(evalVectorsPath)
+	check(err)
+	words := vecs.Vocab()
+	if topN > 0 && topN < len(words) {
+		words = words[:topN]
+	}
+	n, d := len(words), vecs.Dim()
+	if numClusters < 1 || numClusters > n {
+		logln(errorLogLevel, "-k must be between 1 and the number of words (%d)", n)
+	}
+	if bitCodes && numClusters < 2 {
+		logln(errorLogLevel, "-bitcodes needs -k of at least 2 to bisect clusters")
+	}
+	m := make([]real, n*d)
+	for i := 0; i < n; i++ {
+		copy(m[i*d:(i+1)*d], vecs.Row(i))
+	}
+	normalizeRows(m, d)
+
+	logln(infoLogLevel, "clustering %d words into %d clusters", n, numClusters)
+	centroids, assign := sphericalKMeans(m, n, d, numClusters, iterations, true)
+
+	var codes []string
+	if bitCodes {
+		logln(infoLogLevel, "bisecting clusters")
+		codes = make([]string, numClusters)
+		clusters := make([]int, numClusters)
+		for i := range clusters {
+			clusters[i] = i
+		}
+		bisect(centroids, d, clusters, "", codes)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for i, w := range words {
+		if codes != nil {
+			fmt.Fprintf(out, "%s %d %s\n", w, assign[i], codes[assign[i]])
+		} else {
+			fmt.Fprintf(out, "%s %d\n", w, assign[i])
+		}
+	}
+
+	if len(centroidsOutputPath) > 0 {
+		logln(infoLogLevel, "outputting centroids")
+		f, err := os.Create(centroidsOutputPath)
+		check(err)
+		defer f.Close()
+		w := bufio.NewWriter(f)
+		fmt.Fprintf(w, "%d %d\n", numClusters, d)
+		for c := 0; c < numClusters; c++ {
+			fmt.Fprintf(w, "%d", c)
+			for _, x := range centroids[c*d : (c+1)*d] {
+				fmt.Fprintf(w, " %f", x)
+			}
+			w.WriteString("\n")
+		}
+		check(w.Flush())
+	}
+}
+
+// sphericalKMeans clusters the n unit-length rows of m by cosine similarity,
+// running at most maxIter iterations after k-means++ initialization.
+func sphericalKMeans(m []real, n, d, k, maxIter int, verbose bool) (centroids []real, assign []int) {
+	centroids = kMeansPlusPlus(m, n, d, k)
+	assign = make([]int, n)
+	for i := range assign {
+		assign[i] = -1
+	}
+	sums := make([][]real, numThreads)
+	changedPerThread := make([]int, numThreads)
+	simPerThread := make([]real, numThreads)
+	for iter := 0; iter < maxIter; iter++ {
+		var wg sync.WaitGroup
+		for threadID := 0; threadID < numThreads; threadID++ {
+			wg.Add(1)
+			go func(threadID int) {
+				defer wg.Done()
+				sum := make([]real, k*d)
+				changed := 0
+				var totalSim real
+				for i := threadID; i < n; i += numThreads {
+					v := m[i*d : (i+1)*d]
+					best, bestSim := 0, math.Inf(-1)
+					for c := 0; c < k; c++ {
+						if sim := dot(v, centroids[c*d:(c+1)*d]); sim > bestSim {
+							best, bestSim = c, sim
+						}
+					}
+					if assign[i] != best {
+						assign[i] = best
+						changed++
+					}
+					totalSim += bestSim
+					row := sum[best*d : (best+1)*d]
+					for j, x := range v {
+						row[j] += x
+					}
+				}
+				sums[threadID] = sum
+				changedPerThread[threadID] = changed
+				simPerThread[threadID] = totalSim
+			}(threadID)
+		}
+		wg.Wait()
+
+		var changed int
+		var totalSim real
+		for threadID := range sums {
+			changed += changedPerThread[threadID]
+			totalSim += simPerThread[threadID]
+		}
+		// New centroids are the normalized sums. Empty clusters keep theirs.
+		for c := 0; c < k; c++ {
+			row := centroids[c*d : (c+1)*d]
+			for j := range row {
+				var v real
+				for _, sum := range sums {
+					v += sum[c*d+j]
+				}
+				sums[0][c*d+j] = v
+			}
+			if norm(sums[0][c*d:(c+1)*d]) > 0 {
+				copy(row, sums[0][c*d:(c+1)*d])
+			}
+		}
+		normalizeRows(centroids, d)
+		if verbose {
+			logln(infoLogLevel, "iteration %d mean cosine to centroid = %f, reassigned %d", iter, totalSim/real(n), changed)
+		}
+		if changed == 0 {
+			break
+		}
+	}
+	return
+}
+
+// kMeansPlusPlus picks k initial centroids among the rows of m, each with
+// probability proportional to its squared distance to the nearest centroid
+// already picked.
+func kMeansPlusPlus(m []real, n, d, k int) []real {
+	centroids := make([]real, k*d)
+	dist := make([]real, n)
+	for i := range dist {
+		dist[i] = math.Inf(1)
+	}
+	pick := randng.Intn(n)
+	for c := 0; c < k; c++ {
+		centroid := centroids[c*d : (c+1)*d]
+		copy(centroid, m[pick*d:(pick+1)*d])
+		if c == k-1 {
+			break
+		}
+		var wg sync.WaitGroup
+		totals := make([]real, numThreads)
+		for threadID := 0; threadID < numThreads; threadID++ {
+			wg.Add(1)
+			go func(threadID int) {
+				defer wg.Done()
+				for i := threadID; i < n; i += numThreads {
+					// Squared euclidean distance between unit vectors.
+					dd := 2 - 2*dot(m[i*d:(i+1)*d], centroid)
+					if dd < 0 {
+						dd = 0
+					}
+					if dd < dist[i] {
+						dist[i] = dd
+					}
+					totals[threadID] += dist[i]
+				}
+			}(threadID)
+		}
+		wg.Wait()
+		var total real
+		for _, t := range totals {
+			total += t
+		}
+		if total == 0 {
+			pick = randng.Intn(n)
+			continue
+		}
+		r := randng.Float64() * total
+		for pick = 0; pick < n-1; pick++ {
+			r -= dist[pick]
+			if r < 0 {
+				break
+			}
+		}
+	}
+	return centroids
+}
+
+// bisect recursively splits clusters (indices of rows of centroids) in two
+// with spherical 2-means, appending 0 or 1 to the code of each side.
+func bisect(centroids []real, d int, clusters []int, prefix string, codes []string) {
+	if len(clusters) == 1 {
+		codes[clusters[0]] = prefix
+		return
+	}
+	m := make([]real, len(clusters)*d)
+	for i, c := range clusters {
+		copy(m[i*d:(i+1)*d], centroids[c*d:(c+1)*d])
+	}
+	_, assign := sphericalKMeans(m, len(clusters), d, 2, 100, false)
+	var left, right []int
+	for i, c := range clusters {
+		if assign[i] == 0 {
+			left = append(left, c)
+		} else {
+			right = append(right, c)
+		}
+	}
+	// Identical centroids can't be separated, so split them evenly.
+	if len(left) == 0 || len(right) == 0 {
+		left, right = clusters[:len(clusters)/2], clusters[len(clusters)/2:]
+	}
+	bisect(centroids, d, left, prefix+"0", codes)
+	bisect(centroids, d, right, prefix+"1", codes)
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+// compareDefaultTopN is the -topn of compare when not given.
+const compareDefaultTopN = 100000
+
+type comparedWord struct {
+	w            string
+	displacement real
+	jaccard      real
+	freqA, freqB countUint
+}
+
+// compareModels aligns the vectors in compareAPath to those in compareBPath
+// with orthogonal Procrustes over their shared vocabulary and reports how much
+// each word moved.
+func compareModels() {
+	if len(compareAPath) == 0 || len(compareBPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -a and -b are required arguments")
+	}
+	logln(infoLogLevel, "loading vectors")
+	a, err := embedding.LoadText(compareAPath)
+	check(err)
+	b, err := embedding.LoadText(compareBPath)
+	check(err)
+	if a.Dim() != b.Dim() {
+		logln(errorLogLevel, "dimensions differ: %d != %d", a.Dim(), b.Dim())
+	}
+	d := a.Dim()
+
+	// Frequencies are optional. Without them every shared word is compared.
+	freqA := readFreqs(compareVocabAPath)
+	freqB := readFreqs(compareVocabBPath)
+	passesCutoff := func(w string, freqs map[string]*word) bool {
+		if freqs == nil {
+			return true
+		}
+		fw, ok := freqs[w]
+		return ok && fw.freq >= minFreq
+	}
+
+	// Shared words in b's (frequency) order.
+	var shared []string
+	for _, w := range b.Vocab() {
+		if w == ctxBreakToken || !a.Contains(w) || !passesCutoff(w, freqA) || !passesCutoff(w, freqB) {
+			continue
+		}
+		shared = append(shared, w)
+		if topN > 0 && len(shared) == topN {
+			break
+		}
+	}
+	n := len(shared)
+	if n < d {
+		logln(errorLogLevel, "only %d shared words, need at least %d (dim) to align", n, d)
+	}
+	logln(infoLogLevel, "comparing %d shared words", n)
+
+	x := make([]real, n*d)
+	y := make([]real, n*d)
+	for i, w := range shared {
+		va, _ := a.Vector(w)
+		vb, _ := b.Vector(w)
+		copy(x[i*d:], va)
+		copy(y[i*d:], vb)
+	}
+	normalizeRows(x, d)
+	normalizeRows(y, d)
+
+	// Orthogonal Procrustes: W = U V^T where U S V^T = svd(X^T Y).
+	logln(infoLogLevel, "aligning spaces")
+	u, _, v := svd(matMulTransA(x, y, n, d, d), d, d)
+	vt := make([]real, d*d)
+	for i := 0; i < d; i++ {
+		for j := 0; j < d; j++ {
+			vt[i*d+j] = v[j*d+i]
+		}
+	}
+	xw := matMul(x, matMul(u, vt, d, d, d), n, d, d)
+
+	logln(infoLogLevel, "finding %d nearest neighbors", numNeighbors)
+	neighborsA := nearestNeighbors(x, n, d, numNeighbors)
+	neighborsB := nearestNeighbors(y, n, d, numNeighbors)
+
+	results := make([]comparedWord, n)
+	var meanDisplacement, meanJaccard real
+	for i, w := range shared {
+		r := comparedWord{w: w}
+		r.displacement = 1 - dot(xw[i*d:(i+1)*d], y[i*d:(i+1)*d])
+		r.jaccard = jaccard(neighborsA[i], neighborsB[i])
+		if freqA != nil {
+			r.freqA = freqA[w].freq
+		}
+		if freqB != nil {
+			r.freqB = freqB[w].freq
+		}
+		meanDisplacement += r.displacement
+		meanJaccard += r.jaccard
+		results[i] = r
+	}
+	meanDisplacement /= real(n)
+	meanJaccard /= real(n)
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].displacement > results[j].displacement })
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	fmt.Fprintf(out, "# shared words %d, mean cosine displacement %f, mean neighbor jaccard@%d %f\n", n, meanDisplacement, numNeighbors, meanJaccard)
+	fmt.Fprintf(out, "# word displacement jaccard freqa freqb\n")
+	for i, r := range results {
+		if numShow > 0 && i == numShow {
+			break
+		}
+		fmt.Fprintf(out, "%s %f %f %d %d\n", r.w, r.displacement, r.jaccard, r.freqA, r.freqB)
+	}
+}
+
+// readFreqs returns the words of a vocab file, or nil if path is empty.
+func readFreqs(path string) map[string]*word {
+	if len(path) == 0 {
+		return nil
+	}
+	_, freqs := readVocabFile(path)
+	return freqs
+}
+
+func jaccard(a, b []int) real {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	inA := make(map[int]bool, len(a))
+	for _, i := range a {
+		inA[i] = true
+	}
+	var intersection int
+	for _, i := range b {
+		if inA[i] {
+			intersection++
+		}
+	}
+	return real(intersection) / real(len(a)+len(b)-intersection)
+}
@@ -37,6 +37,9 @@ import (
 var byteOrder binary.ByteOrder = binary.LittleEndian
 
 func buildCoocMatrix() {
+	if corpusIsStdin() {
+		logln(errorLogLevel, "train reads the corpus more than once, so it can't be stdin. Use cooc and trainem instead")
+	}
 	logln(debugLogLevel, "initializing cooc storage")
 
 	coocStorage = newDictMatrix(0., vocabSize, ctxVocabSize)
@@ -60,6 +63,7 @@ func buildCoocMatrix() {
 		coocStorage.set(target.idx, mapc.idx, coocStorage.get(target.idx, mapc.idx)+1)
 		return true
 	})
+	logSentenceSplits()
 }
 
 type coocLine struct {
@@ -391,6 +395,7 @@ func buildCoocFile() {
 		}
 		return true
 	})
+	logSentenceSplits()
 	flusher.done()
 	writeCoocTotals(vocabList, coocTotalsPath)
 	writeCoocTotals(ctxVocabList, coocTotalsPath+contextPathSuffix)
@@ -410,7 +415,7 @@ func readCoocTotalsFile(vocab map[string]*word, path string) {
 	logln(infoLogLevel, "reading cooc totals")
 	coocTotalsStream, err := os.Open(path)
 	check(err)
-	readCounts(createScanner(coocTotalsStream), func(w string, cnt countUint) {
+	readCounts(createCountsScanner(coocTotalsStream), func(w string, cnt countUint) {
 		mapw, ok := vocab[w]
 		if !ok {
 			logln(errorLogLevel, "word %s not found in %s", w, path)
@@ -0,0 +1,528 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// -corpus - reads the corpus from stdin.
+const stdinCorpusPath = "-"
+
+// -corpusformat options.
+const (
+	textCorpusFormat  = "text"
+	jsonlCorpusFormat = "jsonl"
+)
+
+var corpusFileList []string
+
+// corpusIsStdin reports whether the corpus is read from stdin, in which case
+// it can only be read once.
+func corpusIsStdin() bool {
+	return corpusPath == stdinCorpusPath
+}
+
+// corpusFiles expands -corpus into the files making up the corpus. It may be
+// a file, a directory (every file within it, recursively), a glob pattern, or
+// @list where list is a file containing one path or pattern per line.
+func corpusFiles() []string {
+	if corpusFileList != nil {
+		return corpusFileList
+	}
+	if len(corpusPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: corpus is a required argument")
+		os.Exit(1)
+	}
+	var patterns []string
+	if corpusPath == stdinCorpusPath {
+		corpusFileList = []string{stdinCorpusPath}
+		return corpusFileList
+	} else if strings.HasPrefix(corpusPath, "@") {
+		list, err := os.Open(corpusPath[1:])
+		check(err)
+		s := bufio.NewScanner(list)
+		for s.Scan() {
+			if line := strings.TrimSpace(s.Text()); len(line) > 0 {
+				patterns = append(patterns, line)
+			}
+		}
+		check(s.Err())
+		list.Close()
+	} else {
+		patterns = []string{corpusPath}
+	}
+	for _, pattern := range patterns {
+		corpusFileList = append(corpusFileList, expandCorpusPath(pattern)...)
+	}
+	if len(corpusFileList) == 0 {
+		logln(errorLogLevel, "no corpus files found in %s", corpusPath)
+	}
+	if len(corpusFileList) > 1 {
+		logln(infoLogLevel, "corpus has %d files", len(corpusFileList))
+	}
+	return corpusFileList
+}
+
+func expandCorpusPath(path string) (files []string) {
+	stat, err := os.Stat(path)
+	if err == nil && stat.IsDir() {
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		check(err)
+		return
+	}
+	if err == nil {
+		return []string{path}
+	}
+	files, err = filepath.Glob(path)
+	check(err)
+	if len(files) == 0 {
+		logln(errorLogLevel, "corpus file %s not found", path)
+	}
+	return
+}
+
+// corpusFilesSize returns the total size in bytes of the corpus files.
+func corpusFilesSize() int64 {
+	if corpusIsStdin() {
+		logln(errorLogLevel, "size of stdin corpus is unknown")
+	}
+	var size int64
+	for _, path := range corpusFiles() {
+		stat, err := os.Stat(path)
+		check(err)
+		size += stat.Size()
+	}
+	return size
+}
+
+// corpusIsCompressed reports whether any corpus file is compressed.
+func corpusIsCompressed() bool {
+	if corpusIsStdin() {
+		logln(errorLogLevel, "cannot peek into stdin corpus")
+	}
+	for _, path := range corpusFiles() {
+		f, err := os.Open(path)
+		check(err)
+		magic := make([]byte, 3)
+		n, _ := io.ReadFull(f, magic)
+		f.Close()
+		if compression(magic[:n]) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// corpusRange returns the bytes of the concatenated corpus files of size
+// bytes read by thread threadID when split among numThreads.
+func corpusRange(threadID int, size int64) (start, end int64) {
+	start = size / int64(numThreads) * int64(threadID)
+	end = size / int64(numThreads) * int64(threadID+1)
+	if threadID == numThreads-1 {
+		end = size
+	}
+	return start, end
+}
+
+// compression detects the compression of a file by its magic number.
+func compression(magic []byte) string {
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return "gzip"
+	case len(magic) >= 3 && string(magic[:3]) == "BZh":
+		return "bzip2"
+	}
+	return ""
+}
+
+// corpusReader reads the concatenation of the corpus files, transparently
+// decompressing gzip and bzip2 files. A newline separates files not ending in
+// one so that windows never span two files.
+type corpusReader struct {
+	files        []string
+	next         int
+	file         *os.File
+	decompressed io.Reader
+	raw          countingReader
+	lastByte     byte
+	// When reading a range of lines, the bytes of the range left to read,
+	// shared across files, and the last byte read from the files.
+	ranged    bool
+	remaining int64
+	lastRaw   byte
+}
+
+// countingReader counts the bytes read from the underlying (possibly
+// compressed) files, used to report progress.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func openCorpus() *corpusReader {
+	return openCorpusAt(0)
+}
+
+// openCorpusAt opens the corpus offset bytes into the concatenation of its
+// files, which must not be compressed unless offset is 0.
+func openCorpusAt(offset int64) *corpusReader {
+	return openCorpusFiles(&corpusReader{files: corpusFiles()}, offset)
+}
+
+// openCorpusRange opens the lines of the corpus starting within [start, end)
+// of the concatenation of its files, which must not be compressed. Ranges
+// splitting the corpus read each of its lines exactly once.
+func openCorpusRange(start, end int64) *corpusReader {
+	c := &corpusReader{files: corpusFiles(), ranged: true, remaining: end - start, lastByte: '\n', lastRaw: '\n'}
+	return openCorpusFiles(c, start)
+}
+
+func openCorpusFiles(c *corpusReader, offset int64) *corpusReader {
+	for ; offset > 0 && c.next < len(c.files); c.next++ {
+		stat, err := os.Stat(c.files[c.next])
+		check(err)
+		if offset < stat.Size() {
+			break
+		}
+		offset -= stat.Size()
+	}
+	if c.next < len(c.files) {
+		c.openNext(offset)
+	}
+	return c
+}
+
+func (c *corpusReader) openNext(offset int64) {
+	f := os.Stdin
+	if c.files[c.next] != stdinCorpusPath {
+		var err error
+		f, err = os.Open(c.files[c.next])
+		check(err)
+	}
+	c.next++
+	seek := offset
+	if c.ranged && offset > 0 {
+		// Look at the previous byte to know if offset starts a line.
+		seek--
+	}
+	if seek > 0 {
+		_, err := f.Seek(seek, 0)
+		check(err)
+	}
+	c.file = f
+	c.raw.r = f
+	br := bufio.NewReader(&c.raw)
+	c.decompressed = br
+	if c.ranged {
+		if offset > 0 {
+			// Skip the line started before the range.
+			b, err := br.ReadByte()
+			for err == nil && b != '\n' {
+				b, err = br.ReadByte()
+				if err == nil {
+					c.remaining--
+				}
+			}
+		}
+		c.lastRaw = '\n'
+		c.decompressed = &corpusRangeReader{c, br}
+	}
+	if offset == 0 {
+		magic, _ := br.Peek(3)
+		switch compression(magic) {
+		case "gzip":
+			gz, err := gzip.NewReader(br)
+			check(err)
+			c.decompressed = gz
+		case "bzip2":
+			c.decompressed = bzip2.NewReader(br)
+		}
+	}
+	if corpusFormat == jsonlCorpusFormat {
+		c.decompressed = newJSONLReader(c.decompressed, jsonlTextField, offset > 0 && !c.ranged)
+	}
+}
+
+// corpusRangeReader reads a file of a ranged corpusReader until the range is
+// exhausted and then up to the end of the line.
+type corpusRangeReader struct {
+	c *corpusReader
+	r *bufio.Reader
+}
+
+func (r *corpusRangeReader) Read(p []byte) (int, error) {
+	c := r.c
+	if c.remaining > 0 {
+		if int64(len(p)) > c.remaining {
+			p = p[:c.remaining]
+		}
+		n, err := r.r.Read(p)
+		c.remaining -= int64(n)
+		if n > 0 {
+			c.lastRaw = p[n-1]
+		}
+		return n, err
+	}
+	n := 0
+	for n < len(p) && c.lastRaw != '\n' {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		p[n] = b
+		n++
+		c.lastRaw = b
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (c *corpusReader) Read(p []byte) (int, error) {
+	for {
+		if c.file == nil {
+			if c.next >= len(c.files) || len(p) == 0 {
+				return 0, io.EOF
+			}
+			if c.ranged && c.remaining <= 0 {
+				// The range ends with this file, which would be separated
+				// from the next one by a context break.
+				if c.lastByte != '\n' {
+					c.lastByte = '\n'
+					p[0] = '\n'
+					return 1, nil
+				}
+				return 0, io.EOF
+			}
+			c.openNext(0)
+			// Files are separated by a context break.
+			if c.lastByte != '\n' {
+				c.lastByte = '\n'
+				p[0] = '\n'
+				return 1, nil
+			}
+			continue
+		}
+		n, err := c.decompressed.Read(p)
+		if n > 0 {
+			c.lastByte = p[n-1]
+		}
+		if err == io.EOF {
+			c.file.Close()
+			c.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *corpusReader) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// rawBytesRead returns how many bytes of the files have been consumed.
+func (c *corpusReader) rawBytesRead() int64 {
+	return c.raw.n
+}
+
+// jsonlReader reads the text field of a file with one JSON document per line,
+// ending each document with a newline so that windows never span two
+// documents.
+type jsonlReader struct {
+	r           *bufio.Reader
+	field       string
+	buf         []byte
+	skipPartial bool
+}
+
+// newJSONLReader returns a reader of the documents in r. If r starts mid-line,
+// skipPartial discards the rest of that line.
+func newJSONLReader(r io.Reader, field string, skipPartial bool) *jsonlReader {
+	return &jsonlReader{r: bufio.NewReader(r), field: field, skipPartial: skipPartial}
+}
+
+func (j *jsonlReader) Read(p []byte) (int, error) {
+	for len(j.buf) == 0 {
+		line, err := j.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return 0, err
+		}
+		if j.skipPartial {
+			j.skipPartial = false
+			continue
+		}
+		j.buf = j.document(line)
+	}
+	n := copy(p, j.buf)
+	j.buf = j.buf[n:]
+	return n, nil
+}
+
+// document extracts the text field of line. Blank lines and documents
+// without the field are skipped.
+func (j *jsonlReader) document(line []byte) []byte {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(line, &doc); err != nil {
+		logln(errorLogLevel, "bad JSON document: %v", err)
+	}
+	raw, ok := doc[j.field]
+	if !ok {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		logln(errorLogLevel, "field %s of JSON document is not a string", j.field)
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	return append([]byte(text), '\n')
+}
+
+// Size of chunks handed to IM training threads when the corpus can't be split
+// by seeking.
+const corpusChunkSize = 4 * 1024 * 1024
+
+type corpusChunk struct {
+	data     []byte
+	rawBytes int64 // bytes of the file consumed to produce data
+}
+
+// readCorpusChunks sends the corpus to out in chunks of whole lines, so that
+// neither characters nor sentences are split, and then closes out.
+func readCorpusChunks(out chan<- corpusChunk) {
+	corpus := openCorpus()
+	defer corpus.Close()
+	r := bufio.NewReaderSize(corpus, corpusChunkSize)
+	var lastRaw int64
+	for {
+		data := make([]byte, corpusChunkSize)
+		n, err := io.ReadFull(r, data)
+		data = data[:n]
+		if err == nil && data[n-1] != '\n' {
+			rest, err := r.ReadBytes('\n')
+			if err != io.EOF {
+				check(err)
+			}
+			data = append(data, rest...)
+		} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+			check(err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		raw := corpus.rawBytesRead()
+		out <- corpusChunk{data, raw - lastRaw}
+		lastRaw = raw
+	}
+	close(out)
+}
+
+// tokenSpool saves the tokens of a corpus that can only be read once, such as
+// stdin, so that they can be read again. Tokens are stored as varint indices
+// into the list of distinct words, which takes far less space than the text.
+type tokenSpool struct {
+	f *os.File
+	w *bufio.Writer
+	b []byte
+}
+
+func newTokenSpool(dir string) *tokenSpool {
+	f, err := ioutil.TempFile(dir, "lexvec-spool-")
+	check(err)
+	logln(infoLogLevel, "spooling corpus tokens to %s", f.Name())
+	return &tokenSpool{f, bufio.NewWriter(f), make([]byte, binary.MaxVarintLen32)}
+}
+
+func (t *tokenSpool) write(idx idxUint) {
+	n := binary.PutUvarint(t.b, uint64(idx))
+	_, err := t.w.Write(t.b[:n])
+	check(err)
+}
+
+// scanner returns a scanner over the spooled tokens, where words[idx] is the
+// word written as idx.
+func (t *tokenSpool) scanner(words []*word) *bufio.Scanner {
+	check(t.w.Flush())
+	_, err := t.f.Seek(0, 0)
+	check(err)
+	s := bufio.NewScanner(bufio.NewReader(t.f))
+	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if len(data) == 0 {
+			return 0, nil, nil
+		}
+		idx, n := binary.Uvarint(data)
+		if n <= 0 {
+			if atEOF {
+				return 0, nil, errors.New("truncated token spool")
+			}
+			return 0, nil, nil
+		}
+		return n, []byte(words[idx].w), nil
+	})
+	return s
+}
+
+func (t *tokenSpool) remove() {
+	t.f.Close()
+	check(os.Remove(t.f.Name()))
+}
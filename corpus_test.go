@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setUpCorpusDir writes a corpus directory of files mostly not ending in a
+// newline, returning its lines in order.
+func setUpCorpusDir(t *testing.T) []string {
+	dir := t.TempDir()
+	files := []string{
+		"a b c\nd e\nf",
+		"g h i j",
+		"",
+		"k\nl m n o p\n",
+		"q r\ns",
+		"t u v w x y z\n\na b",
+	}
+	var lines []string
+	for i, text := range files {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if len(text) > 0 {
+			lines = append(lines, strings.Split(strings.TrimSuffix(text, "\n"), "\n")...)
+		}
+	}
+	corpusPath = dir
+	corpusFileList = nil
+	corpusFormat = textCorpusFormat
+	return lines
+}
+
+// readCorpusLines returns the lines read from the corpus range [start, end).
+func readCorpusLines(t *testing.T, start, end int64) []string {
+	c := openCorpusRange(start, end)
+	defer c.Close()
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestCorpusRangesReadEachLineOnce(t *testing.T) {
+	want := setUpCorpusDir(t)
+	size := corpusFilesSize()
+	defer func(n int) { numThreads = n }(numThreads)
+	for numThreads = 1; numThreads <= 12; numThreads++ {
+		var got []string
+		for threadID := 0; threadID < numThreads; threadID++ {
+			start, end := corpusRange(threadID, size)
+			got = append(got, readCorpusLines(t, start, end)...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d threads: got lines %q, want %q", numThreads, got, want)
+		}
+	}
+}
+
+func TestCorpusRangesSplitAnywhere(t *testing.T) {
+	want := setUpCorpusDir(t)
+	size := corpusFilesSize()
+	// Every split point, including file boundaries and the middle of a
+	// file's last line.
+	for split := int64(0); split <= size; split++ {
+		got := append(readCorpusLines(t, 0, split), readCorpusLines(t, split, size)...)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("split at %d: got lines %q, want %q", split, got, want)
+		}
+	}
+}
+
+func TestCorpusRangeMatchesWholeCorpus(t *testing.T) {
+	setUpCorpusDir(t)
+	c := openCorpus()
+	defer c.Close()
+	whole, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranged := readCorpusLines(t, 0, corpusFilesSize())
+	if got := strings.Join(ranged, "\n"); got != strings.TrimSuffix(string(whole), "\n") {
+		t.Errorf("got %q, want %q", got, whole)
+	}
+}
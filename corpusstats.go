@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Min frequencies at which the number of types is reported, besides -minfreq.
+var corpusStatsMinFreqs = []countUint{1, 2, 5, 10, 20, 50, 100, 1000}
+
+type minFreqStats struct {
+	MinFreq  countUint `json:"minfreq"`
+	Types    int       `json:"types"`
+	Coverage real      `json:"coverage"`
+}
+
+type lengthBin struct {
+	Min       int    `json:"min"`
+	Max       int    `json:"max"`
+	Sentences uint64 `json:"sentences"`
+}
+
+type corpusStats struct {
+	Tokens            uint64          `json:"tokens"`
+	Types             int             `json:"types"`
+	Sentences         uint64          `json:"sentences"`
+	MinFreqs          []minFreqStats  `json:"minfreqs"`
+	SentenceLengths   []lengthBin     `json:"sentence_lengths"`
+	LongSentences     uint64          `json:"sentences_longer_than_maxsentencelen"`
+	SentenceSplits    uint64          `json:"maxsentencelen_splits_upper_bound"`
+	SubsampledByValue map[string]real `json:"subsampled_fraction"`
+	ZipfExponent      evalScore       `json:"zipf_exponent"`
+}
+
+// corpusStatistics writes a JSON report of the corpus as tokenized by
+// buildVocab, to help choose -minfreq, -maxvocab, -subsample and
+// -maxsentencelen. Context breaks are not counted as tokens. Sentence splits
+// are an upper bound, as windower only counts the words of sentences that are
+// in the vocab and not subsampled.
+func corpusStatistics() {
+	corpus := openCorpus()
+	defer corpus.Close()
+	s := createScanner(corpus)
+	counts := make(map[string]countUint)
+	var st corpusStats
+	// lengthCounts[i] counts sentences of length in [2^i, 2^(i+1)).
+	var lengthCounts []uint64
+	endSentence := func(length int) {
+		if length == 0 {
+			return
+		}
+		st.Sentences++
+		bin := 0
+		for l := length; l > 1; l >>= 1 {
+			bin++
+		}
+		for len(lengthCounts) <= bin {
+			lengthCounts = append(lengthCounts, 0)
+		}
+		lengthCounts[bin]++
+		if length > maxSentenceLen {
+			st.LongSentences++
+			st.SentenceSplits += windowerSplits(length)
+		}
+	}
+	logln(infoLogLevel, "counting tokens")
+	pp := newProgressPrinter(defaultProgressInterval)
+	var length int
+	var breaks countUint
+	for s.Scan() {
+		pp.inc()
+		tok := s.Text()
+		if tok == ctxBreakToken {
+			breaks++
+			endSentence(length)
+			length = 0
+			continue
+		}
+		counts[tok]++
+		checkCountIncOverflow(counts[tok])
+		st.Tokens++
+		length++
+	}
+	endSentence(length)
+	st.Types = len(counts)
+	for i, n := range lengthCounts {
+		if n > 0 {
+			st.SentenceLengths = append(st.SentenceLengths, lengthBin{1 << uint(i), 1<<uint(i+1) - 1, n})
+		}
+	}
+
+	freqs := make([]countUint, 0, len(counts))
+	for _, freq := range counts {
+		freqs = append(freqs, freq)
+	}
+	sort.Slice(freqs, func(i, j int) bool { return freqs[i] > freqs[j] })
+
+	thresholds := append([]countUint(nil), corpusStatsMinFreqs...)
+	hasMinFreq := false
+	for _, t := range thresholds {
+		hasMinFreq = hasMinFreq || t == minFreq
+	}
+	if !hasMinFreq {
+		thresholds = append(thresholds, minFreq)
+		sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	}
+	for _, t := range thresholds {
+		var types int
+		var covered uint64
+		for ; types < len(freqs) && freqs[types] >= t; types++ {
+			covered += uint64(freqs[types])
+		}
+		mf := minFreqStats{MinFreq: t, Types: types}
+		if st.Tokens > 0 {
+			mf.Coverage = real(covered) / real(st.Tokens)
+		}
+		st.MinFreqs = append(st.MinFreqs, mf)
+	}
+
+	// Subsampling applies to words in the vocab, i.e. occurring at least
+	// -minfreq times, as in the windower. Their probabilities depend on the
+	// vocab's corpus size, which includes context breaks.
+	var vocabTokens uint64
+	var inVocab int
+	for ; inVocab < len(freqs) && freqs[inVocab] >= minFreq; inVocab++ {
+		vocabTokens += uint64(freqs[inVocab])
+	}
+	vocabCorpusSize := vocabTokens
+	if breaks >= minFreq {
+		vocabCorpusSize += uint64(breaks)
+	}
+	subsamples := []real{subsample}
+	if len(corpusStatsSubsamples) > 0 {
+		subsamples = nil
+		for _, v := range corpusStatsSubsamples {
+			t, err := strconv.ParseFloat(v, 64)
+			check(err)
+			subsamples = append(subsamples, t)
+		}
+	}
+	st.SubsampledByValue = make(map[string]real)
+	for _, t := range subsamples {
+		var removed real
+		for _, freq := range freqs[:inVocab] {
+			w := word{freq: freq}
+			removed += real(freq) * w.subsampleP(t, vocabCorpusSize)
+		}
+		if vocabTokens > 0 {
+			removed /= real(vocabTokens)
+		}
+		st.SubsampledByValue[strconv.FormatFloat(t, 'g', -1, 64)] = removed
+	}
+
+	st.ZipfExponent = evalScore(zipfExponent(freqs[:inVocab]))
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	check(enc.Encode(st))
+}
+
+// zipfExponent fits freq = c * rank^-s by least squares in log-log space,
+// returning s. Ranks are sampled at log-spaced intervals so that the many
+// rare words don't dominate the fit.
+func zipfExponent(freqs []countUint) real {
+	var x, y []real
+	for r := 1; r <= len(freqs); r = int(math.Max(real(r+1), math.Floor(real(r)*1.1))) {
+		x = append(x, math.Log(real(r)))
+		y = append(y, math.Log(real(freqs[r-1])))
+	}
+	if len(x) < 2 {
+		return math.NaN()
+	}
+	var mx, my real
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= real(len(x))
+	my /= real(len(y))
+	var sxy, sxx real
+	for i := range x {
+		sxy += (x[i] - mx) * (y[i] - my)
+		sxx += (x[i] - mx) * (x[i] - mx)
+	}
+	return -sxy / sxx
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package embedding
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+)
+
+// Header values of the binary model written by lexvec -outputsub.
+const (
+	BinaryModelMagicNumber = 0xbea25956
+	BinaryModelVersion     = 1
+)
+
+var byteOrder binary.ByteOrder = binary.LittleEndian
+
+// BinaryModel is the subword model written by lexvec -outputsub. Only the
+// vocabulary is held in memory; vectors are read from the underlying file on
+// demand, so a BinaryModel is safe for concurrent use.
+type BinaryModel struct {
+	r                 io.ReaderAt
+	closer            io.Closer
+	vocabSize         uint32
+	subwordMatrixRows uint32
+	dim               uint32
+	minn, maxn        uint32
+	words             []string
+	index             map[string]uint32
+	matrixBaseOffset  int64
+}
+
+// OpenBinaryModel opens the binary model at path. Call Close when done.
+func OpenBinaryModel(path string) (*BinaryModel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewBinaryModel(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.closer = f
+	return m, nil
+}
+
+// NewBinaryModel parses the header and vocabulary of a binary model.
+func NewBinaryModel(r io.ReaderAt) (*BinaryModel, error) {
+	var offset int64
+	b := make([]byte, 4)
+	readUint32 := func() (uint32, error) {
+		if _, err := r.ReadAt(b, offset); err != nil {
+			return 0, err
+		}
+		offset += 4
+		return byteOrder.Uint32(b), nil
+	}
+	var header [7]uint32
+	for i := range header {
+		v, err := readUint32()
+		if err != nil {
+			return nil, fmt.Errorf("embedding: reading binary model header: %v", err)
+		}
+		header[i] = v
+	}
+	if header[0] != BinaryModelMagicNumber {
+		return nil, fmt.Errorf("embedding: magic number doesnt match")
+	}
+	if header[1] != BinaryModelVersion {
+		return nil, fmt.Errorf("embedding: version number doesnt match")
+	}
+	m := &BinaryModel{
+		r:                 r,
+		vocabSize:         header[2],
+		subwordMatrixRows: header[3],
+		dim:               header[4],
+		minn:              header[5],
+		maxn:              header[6],
+		index:             make(map[string]uint32, header[2]),
+	}
+	for i := uint32(0); i < m.vocabSize; i++ {
+		wLen, err := readUint32()
+		if err != nil {
+			return nil, fmt.Errorf("embedding: reading vocab: %v", err)
+		}
+		wb := make([]byte, wLen)
+		if _, err := r.ReadAt(wb, offset); err != nil {
+			return nil, fmt.Errorf("embedding: reading vocab: %v", err)
+		}
+		offset += int64(wLen)
+		w := string(wb)
+		m.index[w] = uint32(len(m.words))
+		m.words = append(m.words, w)
+	}
+	m.matrixBaseOffset = offset
+	return m, nil
+}
+
+// Close closes the file opened by OpenBinaryModel.
+func (m *BinaryModel) Close() error {
+	if m.closer == nil {
+		return nil
+	}
+	return m.closer.Close()
+}
+
+// Dim returns the number of dimensions of each vector.
+func (m *BinaryModel) Dim() int {
+	return int(m.dim)
+}
+
+// Contains reports whether w was in the training vocabulary.
+func (m *BinaryModel) Contains(w string) bool {
+	_, ok := m.index[w]
+	return ok
+}
+
+// Vocab returns the training vocabulary.
+func (m *BinaryModel) Vocab() []string {
+	return m.words
+}
+
+// MinN and MaxN return the character n-gram lengths used in training. MinN
+// is 0 when the model was trained without n-gram subwords.
+func (m *BinaryModel) MinN() int { return int(m.minn) }
+
+// MaxN returns the maximum character n-gram length used in training.
+func (m *BinaryModel) MaxN() int { return int(m.maxn) }
+
+// Buckets returns the number of subword hash buckets.
+func (m *BinaryModel) Buckets() int {
+	return int(m.subwordMatrixRows - m.vocabSize)
+}
+
+// Vector composes the vector for w exactly as training does: the mean of the
+// word's own vector (if in vocabulary) and the vectors of its character
+// n-grams. When there is nothing to compose, a zero vector is returned along
+// with ErrNotFound.
+func (m *BinaryModel) Vector(w string) ([]float64, error) {
+	return m.VectorWithSubwords(w, nil)
+}
+
+// VectorWithSubwords is Vector with explicit subwords (such as a
+// morphological segmentation) instead of character n-grams. A nil subwords
+// computes n-grams when the model was trained with them.
+func (m *BinaryModel) VectorWithSubwords(w string, subwords []string) ([]float64, error) {
+	if subwords == nil && m.minn > 0 {
+		subwords = ComputeSubwords(w, int(m.minn), int(m.maxn))
+	}
+	v := make([]float64, m.dim)
+	b := make([]byte, m.dim*8)
+	var vLen int
+	if idx, ok := m.index[w]; ok {
+		if err := m.sumRow(v, b, idx); err != nil {
+			return nil, err
+		}
+		vLen++
+	}
+	if m.subwordMatrixRows > m.vocabSize {
+		for _, sw := range subwords {
+			if err := m.sumRow(v, b, SubwordIdx(sw, m.vocabSize, m.subwordMatrixRows-m.vocabSize)); err != nil {
+				return nil, err
+			}
+			vLen++
+		}
+	}
+	if vLen == 0 {
+		return v, ErrNotFound
+	}
+	for j := range v {
+		v[j] /= float64(vLen)
+	}
+	return v, nil
+}
+
+func (m *BinaryModel) sumRow(v []float64, b []byte, idx uint32) error {
+	_, err := m.r.ReadAt(b, m.matrixBaseOffset+int64(m.dim)*int64(idx)*8)
+	if err != nil {
+		return err
+	}
+	for i := range v {
+		v[i] += math.Float64frombits(byteOrder.Uint64(b[8*i : 8*(i+1)]))
+	}
+	return nil
+}
+
+// ComputeSubwords returns the character n-grams of length minn to maxn of
+// "<w>". Lengths are in bytes, as in training.
+func ComputeSubwords(unwrappedw string, minn, maxn int) (subwords []string) {
+	w := fmt.Sprintf("<%s>", unwrappedw)
+	if len(w) < minn {
+		return
+	}
+	for i := 0; i <= len(w)-minn; i++ {
+		for l := minn; l < len(w) && l <= maxn && i+l <= len(w); l++ {
+			subwords = append(subwords, w[i:i+l])
+		}
+	}
+	return
+}
+
+// SubwordIdx returns the row of subword sw in a matrix whose first vocabSize
+// rows are word vectors followed by buckets hashed subword rows.
+func SubwordIdx(sw string, vocabSize, buckets uint32) uint32 {
+	h := fnv.New32()
+	h.Write([]byte(sw))
+	hash := h.Sum32() % buckets
+	return vocabSize + hash
+}
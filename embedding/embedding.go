@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package embedding loads trained LexVec models for inference.
+//
+// Three formats are supported: the text vectors written by -output, the
+// subword binary model written by -outputsub (which can compute vectors for
+// out-of-vocabulary words), and word2vec's binary format.
+package embedding
+
+import (
+	"errors"
+)
+
+// ErrNotFound is returned by Vector when no vector can be produced for a word.
+var ErrNotFound = errors.New("embedding: word not found")
+
+// Embedder maps words to vectors.
+type Embedder interface {
+	// Vector returns the vector for w, or ErrNotFound.
+	Vector(w string) ([]float64, error)
+	// Dim returns the number of dimensions of each vector.
+	Dim() int
+	// Contains reports whether w is in the vocabulary.
+	Contains(w string) bool
+	// Vocab returns the vocabulary in the order it was stored, which for
+	// LexVec output is descending frequency.
+	Vocab() []string
+}
+
+// Dense holds every vector in memory. It is returned by the text and
+// word2vec loaders.
+type Dense struct {
+	words []string
+	index map[string]int
+	dim   int
+	data  []float64
+}
+
+func newDense(dim, capacity int) *Dense {
+	return &Dense{
+		words: make([]string, 0, capacity),
+		index: make(map[string]int, capacity),
+		dim:   dim,
+		data:  make([]float64, 0, capacity*dim),
+	}
+}
+
+func (d *Dense) add(w string, v []float64) {
+	if _, ok := d.index[w]; ok {
+		return
+	}
+	d.index[w] = len(d.words)
+	d.words = append(d.words, w)
+	d.data = append(d.data, v...)
+}
+
+// Vector returns the stored vector for w. The returned slice aliases the
+// underlying matrix and must not be modified.
+func (d *Dense) Vector(w string) ([]float64, error) {
+	i, ok := d.index[w]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return d.Row(i), nil
+}
+
+// Row returns the vector of the i'th vocabulary word.
+func (d *Dense) Row(i int) []float64 {
+	return d.data[i*d.dim : (i+1)*d.dim]
+}
+
+// Index returns the position of w within Vocab.
+func (d *Dense) Index(w string) (int, bool) {
+	i, ok := d.index[w]
+	return i, ok
+}
+
+// Dim returns the number of dimensions of each vector.
+func (d *Dense) Dim() int {
+	return d.dim
+}
+
+// Contains reports whether w has a stored vector.
+func (d *Dense) Contains(w string) bool {
+	_, ok := d.index[w]
+	return ok
+}
+
+// Vocab returns the words in file order.
+func (d *Dense) Vocab() []string {
+	return d.words
+}
+
+// Len returns the number of stored vectors.
+func (d *Dense) Len() int {
+	return len(d.words)
+}
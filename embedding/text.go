@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package embedding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadText reads vectors in the text format written by lexvec -output: an
+// optional "vocabsize dim" header followed by one "word v1 v2 ..." line per
+// word.
+func LoadText(path string) (*Dense, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadText(f)
+}
+
+// ReadText is LoadText for an arbitrary reader.
+func ReadText(r io.Reader) (*Dense, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	var d *Dense
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		parts := strings.Fields(s.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		if d == nil && lineNo == 1 && len(parts) == 2 {
+			n, errN := strconv.Atoi(parts[0])
+			dim, errDim := strconv.Atoi(parts[1])
+			if errN == nil && errDim == nil {
+				d = newDense(dim, n)
+				continue
+			}
+		}
+		if d == nil {
+			d = newDense(len(parts)-1, 0)
+		}
+		if len(parts)-1 != d.dim {
+			return nil, fmt.Errorf("embedding: line %d has %d components, expected %d", lineNo, len(parts)-1, d.dim)
+		}
+		v := make([]float64, d.dim)
+		for j, p := range parts[1:] {
+			x, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return nil, fmt.Errorf("embedding: line %d: %v", lineNo, err)
+			}
+			v[j] = x
+		}
+		d.add(parts[0], v)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("embedding: no vectors found")
+	}
+	return d, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package embedding
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkDense(t *testing.T, d *Dense, words []string, vecs [][]float64) {
+	t.Helper()
+	if !reflect.DeepEqual(d.Vocab(), words) {
+		t.Fatalf("got vocab %v, want %v", d.Vocab(), words)
+	}
+	if d.Dim() != len(vecs[0]) {
+		t.Fatalf("got dim %d, want %d", d.Dim(), len(vecs[0]))
+	}
+	for i, w := range words {
+		v, err := d.Vector(w)
+		if err != nil {
+			t.Fatalf("%s: %v", w, err)
+		}
+		if !reflect.DeepEqual(v, vecs[i]) {
+			t.Errorf("%s: got %v, want %v", w, v, vecs[i])
+		}
+	}
+	if _, err := d.Vector("missing"); err != ErrNotFound {
+		t.Errorf("missing: got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestReadText(t *testing.T) {
+	words := []string{"the", "dog"}
+	vecs := [][]float64{{0.5, -1, 2}, {0, 0.25, -0.125}}
+	for name, text := range map[string]string{
+		"header":    "2 3\nthe 0.5 -1 2\ndog 0 0.25 -0.125\n",
+		"no header": "the 0.5 -1 2\ndog 0 0.25 -0.125\n",
+	} {
+		d, err := ReadText(strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkDense(t, d, words, vecs)
+	}
+}
+
+func TestReadTextBadDim(t *testing.T) {
+	if _, err := ReadText(strings.NewReader("the 1 2\ndog 1\n")); err == nil {
+		t.Error("got no error for vectors of different dims")
+	}
+}
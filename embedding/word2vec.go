@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package embedding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// LoadWord2VecBinary reads vectors saved by word2vec with -binary 1: a
+// "vocabsize dim" text header followed by, for each word, the word, a space
+// and dim little-endian float32s.
+func LoadWord2VecBinary(path string) (*Dense, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadWord2VecBinary(f)
+}
+
+// ReadWord2VecBinary is LoadWord2VecBinary for an arbitrary reader.
+func ReadWord2VecBinary(r io.Reader) (*Dense, error) {
+	br := bufio.NewReader(r)
+	var n, dim int
+	if _, err := fmt.Fscanf(br, "%d %d\n", &n, &dim); err != nil {
+		return nil, fmt.Errorf("embedding: bad word2vec header: %v", err)
+	}
+	d := newDense(dim, n)
+	b := make([]byte, 4*dim)
+	v := make([]float64, dim)
+	for i := 0; i < n; i++ {
+		w, err := br.ReadString(' ')
+		if err != nil {
+			return nil, fmt.Errorf("embedding: reading word %d: %v", i, err)
+		}
+		// word2vec terminates each vector with a newline, which ends up in
+		// front of the next word.
+		w = w[:len(w)-1]
+		for len(w) > 0 && w[0] == '\n' {
+			w = w[1:]
+		}
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, fmt.Errorf("embedding: reading vector for %s: %v", w, err)
+		}
+		for j := range v {
+			v[j] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*j:])))
+		}
+		d.add(w, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package embedding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// word2VecBinary encodes vectors as word2vec -binary 1 does, ending each
+// record with a newline.
+func word2VecBinary(words []string, vecs [][]float64) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %d\n", len(words), len(vecs[0]))
+	b := make([]byte, 4)
+	for i, w := range words {
+		fmt.Fprintf(&buf, "%s ", w)
+		for _, x := range vecs[i] {
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(x)))
+			buf.Write(b)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func TestReadWord2VecBinary(t *testing.T) {
+	words := []string{"the", "dog", "walked"}
+	// Components are exactly representable as float32. The bits of 10 include
+	// a space byte, and the next vector starts with a newline byte.
+	newline := float64(math.Float32frombits(0x3f80000a))
+	vecs := [][]float64{{0.5, -1, 10}, {newline, 0.25, -0.125}, {1, 2, 3}}
+	d, err := ReadWord2VecBinary(bytes.NewReader(word2VecBinary(words, vecs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDense(t, d, words, vecs)
+}
+
+func TestReadWord2VecBinaryTruncated(t *testing.T) {
+	b := word2VecBinary([]string{"the", "dog"}, [][]float64{{1, 2}, {3, 4}})
+	if _, err := ReadWord2VecBinary(bytes.NewReader(b[:len(b)-4])); err == nil {
+		t.Error("got no error for truncated vectors")
+	}
+}
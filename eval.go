@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+// vectorLookup returns the vector for a word, or nil if there is none.
+type vectorLookup func(w string) []real
+
+func embedderLookup(e embedding.Embedder) vectorLookup {
+	return func(w string) []real {
+		v, err := e.Vector(w)
+		if err != nil {
+			if err != embedding.ErrNotFound {
+				check(err)
+			}
+			return nil
+		}
+		return v
+	}
+}
+
+// loadEvalVectors loads the vectors given by -vectors. When -outputsub is also
+// given, words missing from the vectors are composed by the binary model in
+// the same way as the embed command, which alone is used if -vectors is empty.
+// Words are looked up as the tokenizer would have added them to the vocab.
+func loadEvalVectors() (lookup vectorLookup, cleanup func()) {
+	cleanup = func() {}
+	if len(evalVectorsPath) == 0 && len(subvecsOutputPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -vectors and/or -outputsub are required arguments")
+	}
+	var primary, fallback vectorLookup
+	if len(evalVectorsPath) > 0 {
+		logln(infoLogLevel, "loading vectors")
+		d, err := embedding.LoadText(evalVectorsPath)
+		check(err)
+		primary = embedderLookup(d)
+	}
+	if len(subvecsOutputPath) > 0 {
+		logln(infoLogLevel, "loading binary model")
+		m, err := embedding.OpenBinaryModel(subvecsOutputPath)
+		check(err)
+		cleanup = func() { m.Close() }
+		fallback = embedderLookup(m)
+	}
+	return func(w string) []real {
+		w = vocabForm(w)
+		if primary != nil {
+			if v := primary(w); v != nil {
+				return v
+			}
+		}
+		if fallback != nil {
+			return fallback(w)
+		}
+		return nil
+	}, cleanup
+}
+
+func cosine(a, b []real) real {
+	na, nb := norm(a), norm(b)
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot(a, b) / (na * nb)
+}
+
+type simPair struct {
+	w1, w2 string
+	score  real
+}
+
+type simDataset struct {
+	name  string
+	pairs []simPair
+}
+
+// readSimDataset reads "word1 word2 score" lines separated by tabs or spaces.
+// Lines whose third field is not a number (such as headers) are skipped. Words
+// are kept as the tokenizer would have added them to the vocab.
+func readSimDataset(path string) simDataset {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	ds := simDataset{name: datasetName(path)}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		parts := strings.Fields(s.Text())
+		if len(parts) < 3 || strings.HasPrefix(parts[0], "#") {
+			continue
+		}
+		score, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			continue
+		}
+		ds.pairs = append(ds.pairs, simPair{vocabForm(parts[0]), vocabForm(parts[1]), score})
+	}
+	check(s.Err())
+	return ds
+}
+
+func datasetName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// evalScore is a real that is written as null in JSON when undefined (NaN).
+type evalScore real
+
+func (s evalScore) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(real(s)) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(real(s))
+}
+
+type simResult struct {
+	Dataset  string    `json:"dataset"`
+	Pairs    int       `json:"pairs"`
+	Covered  int       `json:"covered"`
+	Coverage real      `json:"coverage"`
+	Spearman evalScore `json:"spearman"`
+}
+
+// evalSim computes the Spearman correlation between human scores and cosine
+// similarity over the pairs for which both words have vectors.
+func evalSim(ds simDataset, lookup vectorLookup) simResult {
+	var human, model []real
+	for _, p := range ds.pairs {
+		v1 := lookup(p.w1)
+		v2 := lookup(p.w2)
+		if v1 == nil || v2 == nil {
+			continue
+		}
+		human = append(human, p.score)
+		model = append(model, cosine(v1, v2))
+	}
+	r := simResult{Dataset: ds.name, Pairs: len(ds.pairs), Covered: len(human)}
+	if r.Pairs > 0 {
+		r.Coverage = real(r.Covered) / real(r.Pairs)
+	}
+	r.Spearman = evalScore(spearman(human, model))
+	return r
+}
+
+func spearman(x, y []real) real {
+	return pearson(ranks(x), ranks(y))
+}
+
+// ranks returns the 1-based rank of each value, averaging ties.
+func ranks(x []real) []real {
+	order := make([]int, len(x))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return x[order[i]] < x[order[j]] })
+	r := make([]real, len(x))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && x[order[j+1]] == x[order[i]] {
+			j++
+		}
+		rank := real(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			r[order[k]] = rank
+		}
+		i = j + 1
+	}
+	return r
+}
+
+func pearson(x, y []real) real {
+	n := real(len(x))
+	if n < 2 {
+		return math.NaN()
+	}
+	var mx, my real
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= n
+	my /= n
+	var sxy, sxx, syy real
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+func evalSimilarity() {
+	if len(evalDatasetPaths) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -data is a required argument")
+	}
+	lookup, cleanup := loadEvalVectors()
+	defer cleanup()
+	var results []simResult
+	for _, path := range evalDatasetPaths {
+		logln(infoLogLevel, "evaluating %s", path)
+		results = append(results, evalSim(readSimDataset(path), lookup))
+	}
+	writeEvalResults(results, func(out *bufio.Writer) {
+		fmt.Fprintf(out, "%-20s %8s %8s %9s %9s\n", "dataset", "pairs", "covered", "coverage", "spearman")
+		for _, r := range results {
+			fmt.Fprintf(out, "%-20s %8d %8d %8.1f%% %9.3f\n", r.Dataset, r.Pairs, r.Covered, r.Coverage*100, r.Spearman)
+		}
+	})
+}
+
+// writeEvalResults writes results to stdout as JSON when -format json is
+// given, otherwise as the table written by text.
+func writeEvalResults(results interface{}, text func(out *bufio.Writer)) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	switch evalFormat {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		check(enc.Encode(results))
+	case "text":
+		text(out)
+	default:
+		logln(errorLogLevel, "invalid option for -format")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// trainEvaluator runs the -evalsim and -evalanalogy datasets on temporary
+// vectors during training, remembering the model with the best score when
+// -keepbest is given.
+type trainEvaluator struct {
+	sim      []simDataset
+	analogy  []analogyDataset
+	next     real
+	best     real
+	bestAt   string
+	bestVec  []real
+	bestCtx  []real
+	finalVec []real
+}
+
+func newTrainEvaluator() *trainEvaluator {
+	if len(evalSimPaths) == 0 && len(evalAnalogyPaths) == 0 {
+		if keepBest {
+			logln(errorLogLevel, "-keepbest requires -evalsim and/or -evalanalogy")
+		}
+		return nil
+	}
+	e := &trainEvaluator{best: math.Inf(-1), next: evalEvery / 100}
+	for _, path := range evalSimPaths {
+		e.sim = append(e.sim, readSimDataset(path))
+	}
+	for _, path := range evalAnalogyPaths {
+		e.analogy = append(e.analogy, readAnalogyDataset(path))
+	}
+	return e
+}
+
+// due reports whether the next -evalevery percent of progress has been
+// reached.
+func (e *trainEvaluator) due() bool {
+	return evalEvery > 0 && progress() >= e.next
+}
+
+// evaluate scores the current model. The caller must make sure SGD threads
+// are not running. The score is the mean of the Spearman correlation of each
+// similarity dataset and the 3CosMul accuracy of each analogy dataset.
+func (e *trainEvaluator) evaluate(at string) {
+	for e.next <= progress() && evalEvery > 0 {
+		e.next += evalEvery / 100
+	}
+	startAt := time.Now()
+
+	// Compute the vectors saveVectors would output without touching mVec.
+	if e.finalVec == nil {
+		e.finalVec = make([]real, vocabSize*dim)
+	}
+	finalizeVectorsInto(e.finalVec)
+	if model == 2 {
+		mergeContextVectorsInto(e.finalVec)
+	}
+	row := func(i int) []real {
+		return e.finalVec[i*int(dim) : (i+1)*int(dim)]
+	}
+	lookup := func(w string) []real {
+		mapw, ok := vocab[w]
+		if !ok {
+			return nil
+		}
+		return row(int(mapw.idx))
+	}
+
+	var scores []string
+	var total real
+	var n int
+	addScore := func(name string, score real) {
+		scores = append(scores, fmt.Sprintf("%s %.4f", name, score))
+		if !math.IsNaN(score) {
+			total += score
+			n++
+		}
+	}
+	for _, ds := range e.sim {
+		addScore(ds.name, real(evalSim(ds, lookup).Spearman))
+	}
+	if len(e.analogy) > 0 {
+		words := make([]string, len(vocabList))
+		for i, w := range vocabList {
+			words[i] = w.w
+		}
+		av := newAnalogyVectors(words, int(dim), row)
+		for _, ds := range e.analogy {
+			results := evalAnalogy(ds, av)
+			addScore(ds.name, real(results[len(results)-1].CosMul))
+		}
+	}
+	score := total / real(n)
+	logln(infoLogLevel, "eval at %s: %s, score %.4f (%.0fs)", at, strings.Join(scores, ", "), score, time.Now().Sub(startAt).Seconds())
+
+	if score > e.best {
+		e.best = score
+		e.bestAt = at
+		if keepBest {
+			if e.bestVec == nil {
+				e.bestVec = make([]real, len(mVec))
+				e.bestCtx = make([]real, len(mCtx))
+			}
+			copy(e.bestVec, mVec)
+			copy(e.bestCtx, mCtx)
+		}
+	}
+}
+
+// restoreBest puts back the model from the best scoring evaluation so that it
+// is the one saved.
+func (e *trainEvaluator) restoreBest() {
+	logln(infoLogLevel, "best score %.4f at %s", e.best, e.bestAt)
+	if !keepBest || e.bestVec == nil {
+		return
+	}
+	logln(infoLogLevel, "keeping vectors from %s", e.bestAt)
+	copy(mVec, e.bestVec)
+	copy(mCtx, e.bestCtx)
+}
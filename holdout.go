@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+// A fraction -holdout of the (word, context) cells is never trained on. Their
+// reconstruction error measures how well the factorization generalizes. It is
+// computed with the current model as each held out cell is drawn, like the
+// training MSE, so no cells are kept in memory.
+
+var heldOutLossPerThread []real
+var heldOutSamplesPerThread []uint64
+
+func initHeldOut() {
+	if holdout <= 0 {
+		return
+	}
+	if holdout >= 1 {
+		logln(errorLogLevel, "-holdout must be less than 1")
+	}
+	heldOutLossPerThread = make([]real, numThreads)
+	heldOutSamplesPerThread = make([]uint64, numThreads)
+}
+
+// resetHeldOut starts measuring held-out error for a new iteration.
+func resetHeldOut() {
+	for i := range heldOutLossPerThread {
+		heldOutLossPerThread[i] = 0
+		heldOutSamplesPerThread[i] = 0
+	}
+}
+
+// isHeldOut deterministically selects cells by hashing their indices, so the
+// same cells are held out by every thread, iteration and run.
+func isHeldOut(wIdx, cIdx idxUint) bool {
+	// splitmix64 finalizer
+	h := uint64(wIdx)<<32 | uint64(cIdx)
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return real(h>>11)/(1<<53) < holdout
+}
+
+// holdOut adds the error of the model on a held out cell each time it is
+// drawn, which weighs it in the loss as SGD would have.
+func holdOut(threadID int, w *word, cIdx idxUint, y real) {
+	zSize := real(len(w.subwords))
+	var dot real
+	trainLock.RLock()
+	for j := idxUint(0); j < dim; j++ {
+		var z real
+		for _, sw := range w.subwords {
+			z += mVec[sw*dim+j]
+		}
+		dot += z / zSize * mCtx[cIdx*dim+j]
+	}
+	trainLock.RUnlock()
+	g := dot - y
+	heldOutLossPerThread[threadID] += 0.5 * g * g
+	heldOutSamplesPerThread[threadID]++
+}
+
+// heldOutLoss returns the mean squared error (as reported for training) over
+// held out cells drawn since the last resetHeldOut and how many were drawn.
+func heldOutLoss() (real, uint64) {
+	var loss real
+	var samples uint64
+	for i := range heldOutLossPerThread {
+		loss += heldOutLossPerThread[i]
+		samples += heldOutSamplesPerThread[i]
+	}
+	return loss / real(samples), samples
+}
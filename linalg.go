@@ -0,0 +1,228 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Dense linear algebra over row-major matrices, used by the commands that
+// analyze trained vectors.
+
+func dot(a, b []real) real {
+	var s real
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+func norm(a []real) real {
+	return math.Sqrt(dot(a, a))
+}
+
+// normalizeRows scales each of the rows of m (each of length cols) to unit
+// length. Zero rows are left untouched.
+func normalizeRows(m []real, cols int) {
+	for i := 0; i+cols <= len(m); i += cols {
+		row := m[i : i+cols]
+		n := norm(row)
+		if n == 0 {
+			continue
+		}
+		for j := range row {
+			row[j] /= n
+		}
+	}
+}
+
+// matMulTransA returns a^T b, where a is n x p and b is n x q.
+func matMulTransA(a, b []real, n, p, q int) []real {
+	r := make([]real, p*q)
+	for i := 0; i < n; i++ {
+		ai := a[i*p : (i+1)*p]
+		bi := b[i*q : (i+1)*q]
+		for j, x := range ai {
+			if x == 0 {
+				continue
+			}
+			rj := r[j*q : (j+1)*q]
+			for k, y := range bi {
+				rj[k] += x * y
+			}
+		}
+	}
+	return r
+}
+
+// matMul returns a b, where a is n x p and b is p x q.
+func matMul(a, b []real, n, p, q int) []real {
+	r := make([]real, n*q)
+	for i := 0; i < n; i++ {
+		ri := r[i*q : (i+1)*q]
+		for j := 0; j < p; j++ {
+			x := a[i*p+j]
+			if x == 0 {
+				continue
+			}
+			bj := b[j*q : (j+1)*q]
+			for k, y := range bj {
+				ri[k] += x * y
+			}
+		}
+	}
+	return r
+}
+
+// svd computes the thin singular value decomposition a = u diag(s) v^T of the
+// m x n matrix a (m >= n) using one-sided Jacobi rotations. u is m x n and v
+// is n x n, both row-major. Singular values are sorted in descending order.
+func svd(a []real, m, n int) (u []real, s []real, v []real) {
+	// Work on columns, so store the transpose: row j of ut is column j of a.
+	ut := make([]real, n*m)
+	for i := 0; i < m; i++ {
+		for j := 0; j < n; j++ {
+			ut[j*m+i] = a[i*n+j]
+		}
+	}
+	vt := make([]real, n*n)
+	for j := 0; j < n; j++ {
+		vt[j*n+j] = 1
+	}
+	const eps = 1e-12
+	for sweep := 0; sweep < 60; sweep++ {
+		rotated := false
+		for j := 0; j < n-1; j++ {
+			cj := ut[j*m : (j+1)*m]
+			for k := j + 1; k < n; k++ {
+				ck := ut[k*m : (k+1)*m]
+				alpha, beta, gamma := dot(cj, cj), dot(ck, ck), dot(cj, ck)
+				if gamma == 0 || math.Abs(gamma) <= eps*math.Sqrt(alpha*beta) {
+					continue
+				}
+				rotated = true
+				zeta := (beta - alpha) / (2 * gamma)
+				t := math.Copysign(1, zeta) / (math.Abs(zeta) + math.Sqrt(1+zeta*zeta))
+				c := 1 / math.Sqrt(1+t*t)
+				sn := c * t
+				for i := 0; i < m; i++ {
+					x, y := cj[i], ck[i]
+					cj[i] = c*x - sn*y
+					ck[i] = sn*x + c*y
+				}
+				vj := vt[j*n : (j+1)*n]
+				vk := vt[k*n : (k+1)*n]
+				for i := 0; i < n; i++ {
+					x, y := vj[i], vk[i]
+					vj[i] = c*x - sn*y
+					vk[i] = sn*x + c*y
+				}
+			}
+		}
+		if !rotated {
+			break
+		}
+	}
+
+	order := make([]int, n)
+	s = make([]real, n)
+	for j := 0; j < n; j++ {
+		order[j] = j
+		s[j] = norm(ut[j*m : (j+1)*m])
+	}
+	sort.Slice(order, func(x, y int) bool { return s[order[x]] > s[order[y]] })
+
+	u = make([]real, m*n)
+	v = make([]real, n*n)
+	sorted := make([]real, n)
+	for newJ, j := range order {
+		sorted[newJ] = s[j]
+		for i := 0; i < m; i++ {
+			if s[j] > 0 {
+				u[i*n+newJ] = ut[j*m+i] / s[j]
+			}
+		}
+		for i := 0; i < n; i++ {
+			v[i*n+newJ] = vt[j*n+i]
+		}
+	}
+	return u, sorted, v
+}
+
+// nearestNeighbors returns, for each of the n unit-length rows of m, the
+// indices of the k rows with highest cosine similarity to it (excluding
+// itself), best first. Rows are split across numThreads goroutines.
+func nearestNeighbors(m []real, n, cols, k int) [][]int {
+	neighbors := make([][]int, n)
+	var wg sync.WaitGroup
+	for threadID := 0; threadID < numThreads; threadID++ {
+		wg.Add(1)
+		go func(threadID int) {
+			defer wg.Done()
+			sims := make([]real, k)
+			for i := threadID; i < n; i += numThreads {
+				neighbors[i] = topKSimilar(m, n, cols, m[i*cols:(i+1)*cols], k, sims, func(j int) bool { return j == i })
+			}
+		}(threadID)
+	}
+	wg.Wait()
+	return neighbors
+}
+
+// topKSimilar returns the indices of the k rows of m with highest dot product
+// with q, best first, skipping rows for which skip returns true. sims is
+// scratch space of length k.
+func topKSimilar(m []real, n, cols int, q []real, k int, sims []real, skip func(j int) bool) []int {
+	if k <= 0 {
+		return nil
+	}
+	best := make([]int, 0, k)
+	sims = sims[:0]
+	for j := 0; j < n; j++ {
+		if skip != nil && skip(j) {
+			continue
+		}
+		sim := dot(q, m[j*cols:(j+1)*cols])
+		if len(best) == k && sim <= sims[k-1] {
+			continue
+		}
+		// Insert keeping best sorted by descending similarity.
+		pos := len(best)
+		if len(best) < k {
+			best = append(best, 0)
+			sims = append(sims, 0)
+		} else {
+			pos = k - 1
+		}
+		for pos > 0 && sims[pos-1] < sim {
+			best[pos] = best[pos-1]
+			sims[pos] = sims[pos-1]
+			pos--
+		}
+		best[pos] = j
+		sims[pos] = sim
+	}
+	return best
+}
@@ -46,7 +46,6 @@ const (
 	debugLogLevel = 2
 
 	ctxBreakToken     = "</s>"
-	maxSentenceLen    = 1000
 	contextPathSuffix = ".context"
 
 	defaultProgressInterval = 10000
@@ -56,6 +55,16 @@ const (
 	externalCoocCommand  = "cooc"
 	externalTrainCommand = "trainem"
 	oovCommand           = "embed"
+	compareCommand       = "compare"
+	evalSimCommand       = "eval-sim"
+	evalAnalogyCommand   = "eval-analogy"
+	statsCommand         = "stats"
+	verifyCommand        = "verify"
+	clusterCommand       = "cluster"
+	probeCommand         = "probe"
+	phrasesCommand       = "phrases"
+	corpusStatsCommand   = "corpusstats"
+	vocabMergeCommand    = "vocabmerge"
 )
 
 // GLOBAL VARS
@@ -68,6 +77,7 @@ var randng *rand.Rand
 var vocabPath string
 var subwordPath string
 var corpusPath, coocPath, coocTotalsPath string
+var corpusFormat, jsonlTextField string
 var vectorOutputPath string
 var subvecsOutputPath string
 
@@ -81,10 +91,26 @@ var ctxVocabSize idxUint
 var ctxVocabList []*word
 var minFreq countUint
 var maxVocab idxUint
-var positionalContexts, periodIsWhitespace bool
+var ctxMinFreq countUint
+var ctxMaxVocab idxUint
+var maxTypes int
+var countsOnly bool
+var includePath, excludePath, excludeRegex string
+var positionalContexts bool
 var ctxbreakw *word
+var maxSentenceLen int
+var slideSentences bool
+var sentenceSplits uint64
 var ctxbreakbytes []byte
 
+// tokenizer
+var tokenization tokenizerSettings
+var corpusTokenizer tokenizer
+var corpusPhrases []phraseSet
+var phrasesPath string
+var phrasePasses int
+var phraseThreshold, phraseDelta real
+
 // subword
 var subwordMinN, subwordMaxN int
 var buckets int
@@ -111,15 +137,31 @@ var associationMeasure associationMeasureFunc
 var clipPmi real
 var processStrategy processStrategyFunc
 var processThreshold real
+var holdout real
+
+// analysis
+var compareAPath, compareBPath string
+var compareVocabAPath, compareVocabBPath string
+var numNeighbors, numShow, topN int
+var evalVectorsPath, evalFormat string
+var evalDatasetPaths stringList
+var numClusters int
+var bitCodes bool
+var centroidsOutputPath string
+var probeLabelsPath string
+var probeFolds int
+var evalSimPaths, evalAnalogyPaths stringList
+var evalEvery real
+var keepBest bool
+var corpusStatsSubsamples stringList
 
 func init() {
 	ctxbreakbytes = []byte(ctxBreakToken)
 }
 
 func main() {
-	randng = rand.New(rand.NewSource(1))
 	flags := flag.NewFlagSet("default", flag.ExitOnError)
-	flags.StringVar(&corpusPath, "corpus", "", "path to corpus")
+	flags.StringVar(&corpusPath, "corpus", "", "path to corpus: a file, directory, glob pattern, @file listing one path per line, or - for stdin (vocab, cooc)")
 	flags.StringVar(&vocabPath, "vocab", "", "path where to output/load vocab")
 	flags.IntVar(&subwordMinN, "minn", 3, "mininum ngram length when generating subwords")
 	flags.IntVar(&subwordMaxN, "maxn", 6, "maximum ngram length when generating subwords")
@@ -129,10 +171,19 @@ func main() {
 	flags.Float64Var(&subsample, "subsample", 1e-5, "subsampling threshold")
 	flags.Float64Var(&contextDistributionSmoothing, "cds", 0.75, "context distribution smoothing")
 	var dimRaw = flags.Int("dim", 300, "number of dimensions of word vectors")
-	flags.IntVar(&iterations, "iterations", 5, "how many times to process corpus")
+	flags.IntVar(&iterations, "iterations", 5, "how many times to process corpus (cluster: max k-means iterations)")
 	flags.IntVar(&window, "window", 2, "symmetric window of (window, word, window)")
+	flags.IntVar(&maxSentenceLen, "maxsentencelen", 1000, "split sentences longer than this many words")
+	flags.BoolVar(&slideSentences, "slidesentences", false, "overlap the parts of split sentences by -window words so that no co-occurrences are lost")
 	var minFreqRaw = flags.Int("minfreq", 100, "remove from vocab words that occur less that this number of times")
 	var maxVocabRaw = flags.Int("maxvocab", 0, "max vocab size, 0 for no limit")
+	var ctxMinFreqRaw = flags.Int("ctxminfreq", 0, "only use as contexts vocab words that occur at least this number of times")
+	var ctxMaxVocabRaw = flags.Int("ctxmaxvocab", 0, "only use as contexts this many of the most frequent vocab words, 0 for no limit")
+	flags.BoolVar(&countsOnly, "countsonly", false, "write the counts of all words of the corpus to -vocab, to be merged with vocabmerge")
+	flags.StringVar(&includePath, "include", "", "path to words (one per line) kept in the vocab if they occur at all, regardless of -minfreq and -maxvocab")
+	flags.StringVar(&excludePath, "exclude", "", "path to words (one per line) never kept in the vocab")
+	flags.StringVar(&excludeRegex, "excluderegex", "", "words matching this regular expression are never kept in the vocab (ex. \"^[0-9]+$\")")
+	flags.IntVar(&maxTypes, "maxtypes", 0, "max distinct words held in memory while counting the vocab (in one thread), pruning the rarest when exceeded (approximate counts), 0 for no limit")
 	flags.IntVar(&negative, "negative", 5, "number of negative samples")
 	flags.Float64Var(&unigramPower, "unigrampow", 0.75, "raise unigram dist to this power")
 	flags.BoolVar(&weightedWindow, "weightwindow", false, "use randomized window size from uniform(1, window)")
@@ -150,6 +201,7 @@ func main() {
 	}
 	var processFuncString = flags.String("process", "all", "which matrix cells to factor ("+strings.Join(processFuncStrings, ",")+")")
 	flags.Float64Var(&processThreshold, "processthreshold", 0, "threshold for -process flag (ex. 1: -process gt and -processthreshold 0 means only process cells > 0, ex. 2: -process leq and -processthreshold 0 means only process cells <= 0)")
+	flags.Float64Var(&holdout, "holdout", 0, "fraction of (word, context) cells excluded from training and used to report held-out MSE")
 	flags.IntVar(&verbose, "verbose", debugLogLevel, "verboseness (0 = errors only, 1 = info, 2 = debug)")
 	flags.StringVar(&coocTotalsPath, "cooctotalspath", "", "path to cooc totals for each word when using external memory")
 	flags.StringVar(&coocPath, "coocpath", "", "path to coocs when using external memory")
@@ -157,12 +209,49 @@ func main() {
 	flags.BoolVar(&positionalContexts, "pos", true, "use positional contexts")
 	flags.StringVar(&vectorOutputPath, "output", "", "where to save vectors")
 	flags.StringVar(&subvecsOutputPath, "outputsub", "", "where to save binary subword vectors")
-	flags.BoolVar(&periodIsWhitespace, "periodiswhitespace", false, "treat period as whitespace")
+	flags.StringVar(&corpusFormat, "corpusformat", textCorpusFormat, "format of corpus files ("+textCorpusFormat+", "+jsonlCorpusFormat+" with one JSON document per line)")
+	flags.StringVar(&jsonlTextField, "textfield", "text", "field holding the text of JSON documents")
+	flags.StringVar(&tokenization.Name, "tokenizer", "default", "how to split the corpus into words ("+strings.Join(tokenizerNames(), ",")+"), default also breaks contexts at periods preceded by whitespace")
+	flags.StringVar(&tokenization.BreakChars, "breakchars", "", "characters that end words and break contexts, in addition to newline (ex. \"!?\")")
+	flags.BoolVar(&tokenization.PeriodIsWhitespace, "periodiswhitespace", false, "treat period as whitespace, ending words (with the default tokenizer every period then breaks contexts)")
+	flags.BoolVar(&tokenization.Lowercase, "lowercase", false, "lowercase words")
+	flags.BoolVar(&tokenization.SplitPunct, "splitpunct", false, "split punctuation from words into separate tokens")
+	flags.IntVar(&tokenization.SegmentChars, "segmentchars", 0, "split Han, Hiragana, Katakana and Thai text into overlapping character n-grams of this length (1 for single characters), 0 to split it at whitespace")
+	flags.IntVar(&tokenization.MaxTokenLen, "maxtokenlen", 0, "drop words longer than this many characters, 0 for no limit")
+	flags.BoolVar(&tokenization.NormDigits, "normdigits", false, "replace digits with # (ex. 1999 becomes ####)")
+	flags.BoolVar(&tokenization.NormURLs, "normurls", false, "replace URLs with "+urlToken)
+	flags.BoolVar(&tokenization.NormEmails, "normemails", false, "replace emails with "+emailToken)
+	flags.BoolVar(&tokenization.NormHandles, "normhandles", false, "replace @handles with "+handleToken)
+	flags.StringVar(&phrasesPath, "phrases", "", "path to phrases merged into single tokens (output of phrases command)")
+	flags.IntVar(&phrasePasses, "phrasepasses", 1, "number of phrase passes, each can merge phrases found in previous ones (phrases)")
+	flags.Float64Var(&phraseThreshold, "phrasethreshold", 100, "minimum score of phrases, lower finds more phrases (phrases)")
+	flags.Float64Var(&phraseDelta, "phrasedelta", 5, "discount subtracted from bigram counts, preventing rare bigrams from becoming phrases (phrases)")
+	flags.StringVar(&compareAPath, "a", "", "path to old vectors (compare)")
+	flags.StringVar(&compareBPath, "b", "", "path to new vectors (compare)")
+	flags.StringVar(&compareVocabAPath, "vocaba", "", "vocab of old vectors, words below -minfreq are not compared (compare)")
+	flags.StringVar(&compareVocabBPath, "vocabb", "", "vocab of new vectors, words below -minfreq are not compared (compare)")
+	flags.IntVar(&numNeighbors, "neighbors", 10, "number of nearest neighbors to compare (compare)")
+	flags.IntVar(&numShow, "show", 100, "number of most changed words to output, 0 for all (compare)")
+	flags.StringVar(&evalVectorsPath, "vectors", "", "path to text vectors to evaluate")
+	flags.Var(&evalDatasetPaths, "data", "evaluation datasets, repeat or separate with commas (eval-sim: lines \"word1 word2 score\", eval-analogy: lines \"a b c d\" with optional \": section\" headers)")
+	flags.Var(&evalSimPaths, "evalsim", "word similarity datasets to evaluate during training, repeat or separate with commas")
+	flags.Var(&evalAnalogyPaths, "evalanalogy", "analogy datasets to evaluate during training, repeat or separate with commas")
+	flags.Float64Var(&evalEvery, "evalevery", 0, "evaluate every this percent of training progress, 0 = after each iteration")
+	flags.BoolVar(&keepBest, "keepbest", false, "save the vectors with the best evaluation score instead of the last (uses twice the memory)")
+	flags.StringVar(&evalFormat, "format", "text", "output format of evaluations (text, json)")
+	flags.IntVar(&numClusters, "k", 1000, "number of clusters (cluster)")
+	flags.BoolVar(&bitCodes, "bitcodes", false, "also output hierarchical bit string codes of clusters found by recursive bisection (cluster)")
+	flags.StringVar(&centroidsOutputPath, "centroids", "", "where to save cluster centroids (cluster)")
+	flags.StringVar(&probeLabelsPath, "labels", "", "words to classify with lines \"word label\" (probe)")
+	flags.IntVar(&probeFolds, "folds", 5, "number of cross-validation folds (probe)")
+	flags.Var(&corpusStatsSubsamples, "subsamples", "subsampling thresholds to report the fraction of tokens removed at, repeat or separate with commas, default -subsample (corpusstats)")
+	var topNRaw = flags.Int("topn", 0, "only use the topn most frequent words, 0 for no limit (compare defaults to "+fmt.Sprint(compareDefaultTopN)+")")
+	var seed = flags.Int64("seed", 1, "random seed")
 	var cpuprofile = flags.String("cpuprofile", "", "write cpu profile to file")
 
 	flags.Usage = func() {
 		fmt.Printf("Usage: lexvec [command] [options]\n" +
-			"Commands: vocab, cooc, train, trainem, embed\n" +
+			"Commands: vocab, cooc, train, trainem, embed, compare, eval-sim, eval-analogy, stats, verify, cluster, probe, phrases, corpusstats, vocabmerge\n" +
 			"Options:\n")
 		flags.PrintDefaults()
 	}
@@ -175,6 +264,20 @@ func main() {
 
 	flags.Parse(os.Args[2:])
 
+	topN = *topNRaw
+	// compare finds nearest neighbors by brute force, which is quadratic in
+	// the number of words, so it only compares the most frequent by default.
+	if command == compareCommand {
+		topN = compareDefaultTopN
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "topn" {
+				topN = *topNRaw
+			}
+		})
+	}
+
+	randng = rand.New(rand.NewSource(*seed))
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		check(err)
@@ -185,6 +288,8 @@ func main() {
 	dim = idxUint(*dimRaw)
 	minFreq = countUint(*minFreqRaw)
 	maxVocab = idxUint(*maxVocabRaw)
+	ctxMinFreq = countUint(*ctxMinFreqRaw)
+	ctxMaxVocab = idxUint(*ctxMaxVocabRaw)
 
 	var ok bool
 	associationMeasure, ok = associationMap[*associationMeasureString]
@@ -197,10 +302,32 @@ func main() {
 		logln(errorLogLevel, "invalid option for -process")
 	}
 
+	if maxSentenceLen < 1 {
+		logln(errorLogLevel, "maxsentencelen must be greater than 0")
+	}
+	if slideSentences && maxSentenceLen <= 2*window {
+		logln(errorLogLevel, "maxsentencelen must be greater than 2 * window when using -slidesentences")
+	}
+
+	if corpusFormat != textCorpusFormat && corpusFormat != jsonlCorpusFormat {
+		logln(errorLogLevel, "invalid option for -corpusformat")
+	}
+	corpusTokenizer = newTokenizer(tokenization)
+	if phrasesPath != "" && command != phrasesCommand {
+		corpusPhrases, tokenization.PhrasesSHA256 = readPhrases(phrasesPath)
+	}
+
 	switch command {
 	case vocabCommand:
+		if countsOnly {
+			countOnlyVocab()
+			break
+		}
 		buildVocab()
 		saveVocab()
+	case vocabMergeCommand:
+		mergeVocab(flags.Args())
+		saveVocab()
 	case trainCommand:
 		readVocab()
 		processSubwords()
@@ -222,6 +349,24 @@ func main() {
 		saveVectors()
 	case oovCommand:
 		calculateOovVectors()
+	case compareCommand:
+		compareModels()
+	case evalSimCommand:
+		evalSimilarity()
+	case evalAnalogyCommand:
+		evalAnalogies()
+	case statsCommand:
+		vectorStatistics()
+	case verifyCommand:
+		verifyArtifacts()
+	case clusterCommand:
+		clusterWords()
+	case probeCommand:
+		probeVectors()
+	case phrasesCommand:
+		buildPhrases()
+	case corpusStatsCommand:
+		corpusStatistics()
 	default:
 		flags.Usage()
 		os.Exit(1)
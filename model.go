@@ -28,10 +28,9 @@ import (
 	"math"
 	"os"
 	"strings"
-)
 
-const binaryModelMagicNumber = 0xbea25956
-const binaryModelVersion = 1
+	"github.com/alexandres/lexvec/embedding"
+)
 
 func initModel() {
 
@@ -85,6 +84,12 @@ func saveVectors() {
 }
 
 func finalizeVectors() {
+	finalizeVectorsInto(mVec)
+}
+
+// finalizeVectorsInto writes the vector of each vocab word (the mean of its
+// subword vectors) into the first vocabSize rows of dst, which may be mVec.
+func finalizeVectorsInto(dst []real) {
 	for _, w := range vocabList {
 		for j := idxUint(0); j < dim; j++ {
 			// j'th component of vector
@@ -93,19 +98,27 @@ func finalizeVectors() {
 			for _, sw := range w.subwords {
 				v += mVec[sw*dim+j]
 			}
-			mVec[w.idx*dim+j] = v / zSize
+			dst[w.idx*dim+j] = v / zSize
 		}
 	}
 }
 
 func mergeContextVectors() {
+	mergeContextVectorsInto(mVec)
+}
+
+// mergeContextVectorsInto adds each vocab word's context vectors to its row
+// in dst. Words left out of the context vocab by -ctxmaxvocab or -ctxminfreq
+// have no context vectors to add.
+func mergeContextVectorsInto(dst []real) {
 	for _, w := range vocabList {
 		for j := idxUint(0); j < dim; j++ {
 			var v float64
 			// If we're not using positional contexts it's simple addition.
 			if !positionalContexts {
-				c, _ := ctxVocab[w.w]
-				v = mCtx[c.idx*dim+j]
+				if c, ok := ctxVocab[w.w]; ok {
+					v = mCtx[c.idx*dim+j]
+				}
 			} else {
 				// We need to sum all of the positional context vectors (one for each
 				// position).
@@ -114,11 +127,12 @@ func mergeContextVectors() {
 						continue
 					}
 					posC := w.posW(k)
-					c, _ := ctxVocab[posC]
-					v += mCtx[c.idx*dim+j]
+					if c, ok := ctxVocab[posC]; ok {
+						v += mCtx[c.idx*dim+j]
+					}
 				}
 			}
-			mVec[w.idx*dim+j] += v
+			dst[w.idx*dim+j] += v
 		}
 	}
 }
@@ -143,8 +157,8 @@ func saveBinaryModel() {
 	check(err)
 	subvecsOutputStream := bufio.NewWriter(subvecsOutput)
 	b := make([]byte, float64Bytes)
-	binaryModelWriteUint32(subvecsOutputStream, b, binaryModelMagicNumber)
-	binaryModelWriteUint32(subvecsOutputStream, b, binaryModelVersion)
+	binaryModelWriteUint32(subvecsOutputStream, b, embedding.BinaryModelMagicNumber)
+	binaryModelWriteUint32(subvecsOutputStream, b, embedding.BinaryModelVersion)
 	binaryModelWriteUint32(subvecsOutputStream, b, vocabSize)
 	binaryModelWriteUint32(subvecsOutputStream, b, subwordMatrixRows)
 	binaryModelWriteUint32(subvecsOutputStream, b, dim)
@@ -172,53 +186,18 @@ func binaryModelWriteUint32(w *bufio.Writer, b []byte, v uint32) {
 	check(err)
 }
 
-func binaryModelReadUint32(f *os.File, b []byte) uint32 {
-	_, err := f.Read(b[:uint32Bytes])
-	check(err)
-	return byteOrder.Uint32(b[:uint32Bytes])
-}
-
 func calculateOovVectors() {
 	logln(infoLogLevel, "loading binary model")
-	subvecsOutput, err := os.Open(subvecsOutputPath)
-	defer subvecsOutput.Close()
+	m, err := embedding.OpenBinaryModel(subvecsOutputPath)
 	check(err)
-	b := make([]byte, float64Bytes)
-
-	magicNumber := binaryModelReadUint32(subvecsOutput, b)
-	if magicNumber != binaryModelMagicNumber {
-		logln(errorLogLevel, "magic number doesnt match")
+	defer m.Close()
+	if vocabPath != "" {
+		checkTokenizerSettings(vocabPath + tokenizerPathSuffix)
 	}
-	version := binaryModelReadUint32(subvecsOutput, b)
-	if version != binaryModelVersion {
-		logln(errorLogLevel, "version number doesnt match")
-	}
-	vocabSize := binaryModelReadUint32(subvecsOutput, b)
-	subwordMatrixRows := binaryModelReadUint32(subvecsOutput, b)
-	dim := binaryModelReadUint32(subvecsOutput, b)
-	subwordMinN := binaryModelReadUint32(subvecsOutput, b)
-	subwordMaxN := binaryModelReadUint32(subvecsOutput, b)
-
-	var ivWords []string
-	ivWordToIdx := make(map[string]int)
-	for i := 0; i < int(vocabSize); i++ {
-
-		wLen := binaryModelReadUint32(subvecsOutput, b)
-
-		b := make([]byte, wLen)
-		_, err := subvecsOutput.Read(b)
-		check(err)
-		w := string(b)
-		ivWordToIdx[w] = len(ivWords)
-		ivWords = append(ivWords, w)
-	}
-	matrixBaseOffset, err := subvecsOutput.Seek(0, 1)
-	check(err)
 
 	s := bufio.NewScanner(os.Stdin)
 	s.Split(bufio.ScanLines)
 	logln(infoLogLevel, "reading oov words")
-	v := make([]float64, dim)
 	out := bufio.NewWriter(os.Stdout)
 	pp := newProgressPrinter(1000)
 	for s.Scan() {
@@ -230,27 +209,13 @@ func calculateOovVectors() {
 		parts := strings.Split(line, " ")
 		w := parts[0]
 		var subwords []string
-		if subwordMinN > 0 && len(parts) == 1 {
-			subwords = computeSubwords(w, int(subwordMinN), int(subwordMaxN))
-		} else {
+		if len(parts) > 1 {
 			subwords = parts[1:]
 		}
-		for j := 0; j < int(dim); j++ {
-			v[j] = 0
-		}
-		var vLen int
-		if idx, ok := ivWordToIdx[w]; ok {
-			sumVecFromBin(subvecsOutput, matrixBaseOffset, v, idxUint(idx))
-			vLen++
-		}
-		for _, sw := range subwords {
-			sumVecFromBin(subvecsOutput, matrixBaseOffset, v, subwordIdx(sw, vocabSize, subwordMatrixRows-vocabSize))
-			vLen++
-		}
-		if vLen > 0 {
-			for j := 0; j < int(dim); j++ {
-				v[j] /= float64(vLen)
-			}
+		// A word with nothing to compose is output as the zero vector.
+		v, err := m.VectorWithSubwords(vocabForm(w), subwords)
+		if err != nil && err != embedding.ErrNotFound {
+			check(err)
 		}
 		_, err = out.WriteString(w)
 		check(err)
@@ -264,16 +229,3 @@ func calculateOovVectors() {
 		check(err)
 	}
 }
-
-func sumVecFromBin(f *os.File, base int64, v []float64, idx idxUint) {
-	dim := len(v)
-	_, err := f.Seek(base+int64(dim)*int64(idx)*float64Bytes, 0)
-	check(err)
-	b := make([]byte, dim*float64Bytes)
-	_, err = f.Read(b)
-	check(err)
-	for i := range v {
-		z := math.Float64frombits(byteOrder.Uint64(b[float64Bytes*i : float64Bytes*(i+1)]))
-		v[i] += z
-	}
-}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+// setUpBinaryModel builds a small vocab with n-gram subwords and random
+// vectors, as left by training.
+func setUpBinaryModel(t *testing.T) {
+	dim = 4
+	subwordMinN, subwordMaxN = 3, 4
+	buckets = 50
+	subwordPath = ""
+	vocabList = nil
+	vocab = make(map[string]*word)
+	for i, w := range []string{"the", "walked", "dog", ctxBreakToken} {
+		vocabList = append(vocabList, &word{w: w, idx: idxUint(i)})
+		vocab[w] = vocabList[i]
+	}
+	vocabSize = idxUint(len(vocabList))
+	processSubwords()
+	r := rand.New(rand.NewSource(1))
+	mVec = make([]real, subwordMatrixRows*dim)
+	for i := range mVec {
+		mVec[i] = r.Float64() - 0.5
+	}
+	subvecsOutputPath = filepath.Join(t.TempDir(), "model.bin")
+	saveBinaryModel()
+}
+
+func TestBinaryModelRoundTrip(t *testing.T) {
+	setUpBinaryModel(t)
+	m, err := embedding.OpenBinaryModel(subvecsOutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Dim() != int(dim) || m.MinN() != subwordMinN || m.MaxN() != subwordMaxN || m.Buckets() != buckets {
+		t.Errorf("got dim %d minn %d maxn %d buckets %d, want %d %d %d %d", m.Dim(), m.MinN(), m.MaxN(), m.Buckets(), dim, subwordMinN, subwordMaxN, buckets)
+	}
+	words := m.Vocab()
+	if len(words) != len(vocabList) {
+		t.Fatalf("got %d words, want %d", len(words), len(vocabList))
+	}
+	for i, w := range vocabList {
+		if words[i] != w.w || !m.Contains(w.w) {
+			t.Errorf("word %d: got %q, want %q", i, words[i], w.w)
+		}
+	}
+	if m.Contains("cat") {
+		t.Error("OOV word cat is in vocab")
+	}
+}
+
+func TestBinaryModelVectorMatchesTraining(t *testing.T) {
+	setUpBinaryModel(t)
+	want := make([]real, vocabSize*dim)
+	finalizeVectorsInto(want)
+	m, err := embedding.OpenBinaryModel(subvecsOutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	for _, w := range vocabList {
+		v, err := m.Vector(w.w)
+		if err != nil {
+			t.Fatalf("%s: %v", w.w, err)
+		}
+		for j, x := range v {
+			if x != want[w.idx*dim+idxUint(j)] {
+				t.Errorf("%s: got %v, want %v", w.w, v, want[w.idx*dim:(w.idx+1)*dim])
+				break
+			}
+		}
+	}
+
+	// An OOV word is the mean of its n-gram rows.
+	subwords := embedding.ComputeSubwords("walk", subwordMinN, subwordMaxN)
+	wantOOV := make([]real, dim)
+	for _, sw := range subwords {
+		row := embedding.SubwordIdx(sw, vocabSize, idxUint(buckets))
+		for j := range wantOOV {
+			wantOOV[j] += mVec[row*dim+idxUint(j)]
+		}
+	}
+	v, err := m.Vector("walk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := range wantOOV {
+		if v[j] != wantOOV[j]/real(len(subwords)) {
+			t.Errorf("walk: got %v, want mean of n-grams %v", v, wantOOV)
+			break
+		}
+	}
+}
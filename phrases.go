@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// phraseJoiner joins the words of a phrase into a single token.
+const phraseJoiner = "_"
+
+// phraseSet holds the bigrams merged in one pass.
+type phraseSet map[[2]string]bool
+
+// phraseMerger merges consecutive tokens of s forming a phrase of the set
+// into one token. As in word2phrase, a merged token is not merged again
+// within the same pass.
+type phraseMerger struct {
+	s       tokenStream
+	phrases phraseSet
+	tok     string
+	next    string
+	hasNext bool
+}
+
+func newPhraseMerger(s tokenStream, phrases phraseSet) *phraseMerger {
+	return &phraseMerger{s: s, phrases: phrases}
+}
+
+func (m *phraseMerger) Scan() bool {
+	if m.hasNext {
+		m.tok = m.next
+		m.hasNext = false
+	} else if m.s.Scan() {
+		m.tok = m.s.Text()
+	} else {
+		return false
+	}
+	if m.tok == ctxBreakToken || !m.s.Scan() {
+		return true
+	}
+	next := m.s.Text()
+	if m.phrases[[2]string{m.tok, next}] {
+		m.tok += phraseJoiner + next
+	} else {
+		m.next = next
+		m.hasNext = true
+	}
+	return true
+}
+
+func (m *phraseMerger) Text() string {
+	return m.tok
+}
+
+type phrase struct {
+	pass  int
+	a, b  string
+	score float64
+}
+
+// readPhrases reads the phrases file written by the phrases command,
+// returning the set of phrases of each pass and the SHA-256 of the file,
+// which is recorded in the tokenizer settings so that a regenerated file is
+// told apart from the one the vocab was built with.
+func readPhrases(path string) ([]phraseSet, string) {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	var passes []phraseSet
+	h := sha256.New()
+	s := bufio.NewScanner(io.TeeReader(f, h))
+	for s.Scan() {
+		parts := strings.Fields(s.Text())
+		if len(parts) != 4 {
+			logln(errorLogLevel, "bad phrase line: %s", s.Text())
+		}
+		pass, err := strconv.Atoi(parts[0])
+		check(err)
+		if pass < 1 {
+			logln(errorLogLevel, "bad phrase pass: %s", s.Text())
+		}
+		for len(passes) < pass {
+			passes = append(passes, make(phraseSet))
+		}
+		passes[pass-1][[2]string{parts[1], parts[2]}] = true
+	}
+	check(s.Err())
+	return passes, hex.EncodeToString(h.Sum(nil))
+}
+
+// findPhrases scores the bigrams of the corpus as tokenized with the phrases
+// of previous passes, returning those scoring above phraseThreshold.
+func findPhrases(pass int, previous []phraseSet) []phrase {
+	corpus := openCorpus()
+	defer corpus.Close()
+	var s tokenStream = createScanner(corpus)
+	for _, p := range previous {
+		s = newPhraseMerger(s, p)
+	}
+
+	ids := make(map[string]uint32)
+	var words []string
+	var counts []uint64
+	bigrams := make(map[uint64]uint64)
+	var total uint64
+	var prev uint32
+	hasPrev := false
+	pp := newProgressPrinter(defaultProgressInterval)
+	for s.Scan() {
+		pp.inc()
+		tok := s.Text()
+		if tok == ctxBreakToken {
+			hasPrev = false
+			continue
+		}
+		id, ok := ids[tok]
+		if !ok {
+			id = uint32(len(words))
+			ids[tok] = id
+			words = append(words, tok)
+			counts = append(counts, 0)
+		}
+		counts[id]++
+		total++
+		if hasPrev {
+			bigrams[uint64(prev)<<32|uint64(id)]++
+		}
+		prev = id
+		hasPrev = true
+	}
+	logln(infoLogLevel, "pass %d: %d tokens, %d types, %d bigrams", pass, total, len(words), len(bigrams))
+
+	var found []phrase
+	for k, cnt := range bigrams {
+		a, b := uint32(k>>32), uint32(k)
+		// word2phrase score, scaled by the number of tokens so that the
+		// threshold does not depend on corpus size.
+		score := (float64(cnt) - phraseDelta) / float64(counts[a]) / float64(counts[b]) * float64(total)
+		if score > phraseThreshold {
+			found = append(found, phrase{pass, words[a], words[b], score})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].score != found[j].score {
+			return found[i].score > found[j].score
+		}
+		if found[i].a != found[j].a {
+			return found[i].a < found[j].a
+		}
+		return found[i].b < found[j].b
+	})
+	return found
+}
+
+func buildPhrases() {
+	if phrasesPath == "" {
+		logln(errorLogLevel, "no phrases path given")
+	}
+	if phrasePasses < 1 {
+		logln(errorLogLevel, "phrasepasses must be greater than 0")
+	}
+	if corpusIsStdin() && phrasePasses > 1 {
+		logln(errorLogLevel, "phrases reads the corpus once per pass, so it can't be stdin with more than one pass")
+	}
+	output, err := os.Create(phrasesPath)
+	check(err)
+	defer output.Close()
+	w := bufio.NewWriter(output)
+	var passes []phraseSet
+	for pass := 1; pass <= phrasePasses; pass++ {
+		logln(infoLogLevel, "finding phrases, pass %d", pass)
+		found := findPhrases(pass, passes)
+		logln(infoLogLevel, "pass %d: found %d phrases", pass, len(found))
+		set := make(phraseSet)
+		for _, p := range found {
+			set[[2]string{p.a, p.b}] = true
+			_, err := fmt.Fprintf(w, "%d %s %s %f\n", p.pass, p.a, p.b, p.score)
+			check(err)
+		}
+		passes = append(passes, set)
+	}
+	check(w.Flush())
+}
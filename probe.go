@@ -0,0 +1,270 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	probeEpochs       = 50
+	probeLearningRate = 0.1
+	probeL2           = 1e-4
+)
+
+type probeClassResult struct {
+	Class     string    `json:"class"`
+	Support   int       `json:"support"`
+	Precision evalScore `json:"precision"`
+	Recall    evalScore `json:"recall"`
+	F1        evalScore `json:"f1"`
+}
+
+type probeResult struct {
+	Words    int                `json:"words"`
+	Covered  int                `json:"covered"`
+	Coverage real               `json:"coverage"`
+	Folds    int                `json:"folds"`
+	Accuracy real               `json:"accuracy"`
+	MacroF1  real               `json:"macro_f1"`
+	Classes  []probeClassResult `json:"classes"`
+}
+
+// probeVectors trains a multinomial logistic regression predicting the labels
+// of -labels ("word label" lines) from word vectors, reporting accuracy and
+// per-class F1 over -folds cross-validation folds.
+func probeVectors() {
+	if len(probeLabelsPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -labels is a required argument")
+	}
+	if probeFolds < 2 {
+		logln(errorLogLevel, "-folds must be at least 2")
+	}
+	lookup, cleanup := loadEvalVectors()
+	defer cleanup()
+
+	f, err := os.Open(probeLabelsPath)
+	check(err)
+	defer f.Close()
+	var xs [][]real
+	var ys []int
+	var classes []string
+	classIdx := make(map[string]int)
+	words := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		parts := strings.Fields(s.Text())
+		if len(parts) < 2 {
+			continue
+		}
+		words++
+		v := lookup(parts[0])
+		if v == nil {
+			continue
+		}
+		c, ok := classIdx[parts[1]]
+		if !ok {
+			c = len(classes)
+			classIdx[parts[1]] = c
+			classes = append(classes, parts[1])
+		}
+		xs = append(xs, v)
+		ys = append(ys, c)
+	}
+	check(s.Err())
+	if len(xs) < probeFolds || len(classes) < 2 {
+		logln(errorLogLevel, "need at least %d labeled words with vectors and 2 classes", probeFolds)
+	}
+	logln(infoLogLevel, "probing %d words with %d classes", len(xs), len(classes))
+
+	// Assign each example to a fold at random.
+	order := randng.Perm(len(xs))
+	fold := make([]int, len(xs))
+	for i, j := range order {
+		fold[j] = i % probeFolds
+	}
+
+	predictions := make([]int, len(xs))
+	var wg sync.WaitGroup
+	sem := make(chan bool, numThreads)
+	for k := 0; k < probeFolds; k++ {
+		wg.Add(1)
+		sem <- true
+		go func(k int) {
+			defer wg.Done()
+			var train, test []int
+			for i := range xs {
+				if fold[i] == k {
+					test = append(test, i)
+				} else {
+					train = append(train, i)
+				}
+			}
+			lr := trainLogisticRegression(xs, ys, train, len(classes), rand.New(rand.NewSource(int64(k))))
+			for _, i := range test {
+				predictions[i] = lr.predict(xs[i])
+			}
+			<-sem
+		}(k)
+	}
+	wg.Wait()
+
+	r := probeResult{Words: words, Covered: len(xs), Coverage: real(len(xs)) / real(words), Folds: probeFolds}
+	tp := make([]int, len(classes))
+	predicted := make([]int, len(classes))
+	support := make([]int, len(classes))
+	correct := 0
+	for i, y := range ys {
+		support[y]++
+		predicted[predictions[i]]++
+		if predictions[i] == y {
+			tp[y]++
+			correct++
+		}
+	}
+	r.Accuracy = real(correct) / real(len(ys))
+	for c, name := range classes {
+		precision := real(tp[c]) / real(predicted[c])
+		recall := real(tp[c]) / real(support[c])
+		f1 := 2 * precision * recall / (precision + recall)
+		if tp[c] == 0 {
+			f1 = 0
+		}
+		r.MacroF1 += f1
+		r.Classes = append(r.Classes, probeClassResult{name, support[c], evalScore(precision), evalScore(recall), evalScore(f1)})
+	}
+	r.MacroF1 /= real(len(classes))
+	sort.Slice(r.Classes, func(i, j int) bool { return r.Classes[i].Support > r.Classes[j].Support })
+
+	writeEvalResults(r, func(out *bufio.Writer) {
+		fmt.Fprintf(out, "words %d, covered %d (%.1f%%), %d folds, accuracy %.2f%%, macro F1 %.4f\n", r.Words, r.Covered, r.Coverage*100, r.Folds, r.Accuracy*100, r.MacroF1)
+		fmt.Fprintf(out, "%-20s %8s %9s %9s %9s\n", "class", "support", "precision", "recall", "f1")
+		for _, c := range r.Classes {
+			fmt.Fprintf(out, "%-20s %8d %9.4f %9.4f %9.4f\n", c.Class, c.Support, c.Precision, c.Recall, c.F1)
+		}
+	})
+}
+
+// logisticRegression is a multinomial logistic regression over standardized
+// features.
+type logisticRegression struct {
+	mean, std []real
+	weights   []real // classes x (dim + 1), last column is the bias
+	classes   int
+	dim       int
+}
+
+func trainLogisticRegression(xs [][]real, ys []int, train []int, classes int, r *rand.Rand) *logisticRegression {
+	d := len(xs[0])
+	lr := &logisticRegression{make([]real, d), make([]real, d), make([]real, classes*(d+1)), classes, d}
+	for _, i := range train {
+		for j, x := range xs[i] {
+			lr.mean[j] += x
+		}
+	}
+	for j := range lr.mean {
+		lr.mean[j] /= real(len(train))
+	}
+	for _, i := range train {
+		for j, x := range xs[i] {
+			lr.std[j] += (x - lr.mean[j]) * (x - lr.mean[j])
+		}
+	}
+	for j := range lr.std {
+		lr.std[j] = math.Sqrt(lr.std[j] / real(len(train)))
+		if lr.std[j] == 0 {
+			lr.std[j] = 1
+		}
+	}
+
+	x := make([]real, d)
+	p := make([]real, classes)
+	order := append([]int(nil), train...)
+	for epoch := 0; epoch < probeEpochs; epoch++ {
+		alpha := probeLearningRate * (1 - real(epoch)/probeEpochs)
+		r.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+		for _, i := range order {
+			lr.standardize(xs[i], x)
+			lr.probabilities(x, p)
+			for c := 0; c < classes; c++ {
+				g := p[c]
+				if c == ys[i] {
+					g--
+				}
+				w := lr.weights[c*(d+1) : (c+1)*(d+1)]
+				for j, xj := range x {
+					w[j] -= alpha * (g*xj + probeL2*w[j])
+				}
+				w[d] -= alpha * g
+			}
+		}
+	}
+	return lr
+}
+
+func (lr *logisticRegression) standardize(v, x []real) {
+	for j := range x {
+		x[j] = (v[j] - lr.mean[j]) / lr.std[j]
+	}
+}
+
+// probabilities writes the softmax of the class scores of x into p.
+func (lr *logisticRegression) probabilities(x, p []real) {
+	maxScore := math.Inf(-1)
+	for c := range p {
+		w := lr.weights[c*(lr.dim+1) : (c+1)*(lr.dim+1)]
+		p[c] = dot(w[:lr.dim], x) + w[lr.dim]
+		if p[c] > maxScore {
+			maxScore = p[c]
+		}
+	}
+	var z real
+	for c := range p {
+		p[c] = math.Exp(p[c] - maxScore)
+		z += p[c]
+	}
+	for c := range p {
+		p[c] /= z
+	}
+}
+
+func (lr *logisticRegression) predict(v []real) int {
+	x := make([]real, lr.dim)
+	p := make([]real, lr.classes)
+	lr.standardize(v, x)
+	lr.probabilities(x, p)
+	best := 0
+	for c := range p {
+		if p[c] > p[best] {
+			best = c
+		}
+	}
+	return best
+}
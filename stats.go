@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/alexandres/lexvec/embedding"
+)
+
+const (
+	statsPrincipalComponents = 10
+	statsRandomPairs         = 100000
+)
+
+type normStats struct {
+	Min         real            `json:"min"`
+	Max         real            `json:"max"`
+	Mean        real            `json:"mean"`
+	Std         real            `json:"std"`
+	Percentiles map[string]real `json:"percentiles"`
+	FreqCorr    evalScore       `json:"spearman_with_freq"`
+}
+
+type vectorStats struct {
+	Vectors              int       `json:"vectors"`
+	Dim                  int       `json:"dim"`
+	NaN                  int       `json:"nan"`
+	Inf                  int       `json:"inf"`
+	Zero                 int       `json:"zero"`
+	Norm                 normStats `json:"norm"`
+	MeanVectorNorm       real      `json:"mean_vector_norm"`
+	MeanVector           []real    `json:"mean_vector"`
+	PCVarianceRatios     []real    `json:"pc_variance_ratios"`
+	RandomPairMeanCosine real      `json:"random_pair_mean_cosine"`
+}
+
+var statsPercentiles = []real{0.01, 0.05, 0.25, 0.5, 0.75, 0.95, 0.99}
+
+// vectorStatistics writes a JSON report of the geometry of the vectors given
+// by -vectors, restricted to the -topn most frequent words if given.
+// Frequencies for the norm correlation are read from -vocab if given.
+func vectorStatistics() {
+	if len(evalVectorsPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: -vectors is a required argument")
+	}
+	logln(infoLogLevel, "loading vectors")
+	vecs, err := embedding.LoadText(evalVectorsPath)
+	check(err)
+	words := vecs.Vocab()
+	if topN > 0 && topN < len(words) {
+		words = words[:topN]
+	}
+	d := vecs.Dim()
+	st := vectorStats{Vectors: len(words), Dim: d}
+
+	// Vectors containing NaNs or infinities are counted and then ignored.
+	var valid [][]real
+	var norms []real
+	var validWords []string
+	for _, w := range words {
+		v, _ := vecs.Vector(w)
+		hasNaN, hasInf := false, false
+		for _, x := range v {
+			if math.IsNaN(x) {
+				hasNaN = true
+				break
+			}
+			if math.IsInf(x, 0) {
+				hasInf = true
+			}
+		}
+		if hasNaN {
+			st.NaN++
+			continue
+		}
+		if hasInf {
+			st.Inf++
+			continue
+		}
+		n := norm(v)
+		if n == 0 {
+			st.Zero++
+		}
+		valid = append(valid, v)
+		norms = append(norms, n)
+		validWords = append(validWords, w)
+	}
+	if len(valid) == 0 {
+		logln(infoLogLevel, "no valid vectors")
+		st.Norm.FreqCorr = evalScore(math.NaN())
+		writeVectorStats(st)
+		return
+	}
+
+	logln(infoLogLevel, "computing norms")
+	st.Norm = computeNormStats(norms)
+	if len(vocabPath) > 0 {
+		_, freqs := readVocabFile(vocabPath)
+		var x, y []real
+		for i, w := range validWords {
+			if fw, ok := freqs[w]; ok {
+				x = append(x, norms[i])
+				y = append(y, real(fw.freq))
+			}
+		}
+		st.Norm.FreqCorr = evalScore(spearman(x, y))
+	} else {
+		st.Norm.FreqCorr = evalScore(math.NaN())
+	}
+
+	st.MeanVector = make([]real, d)
+	for _, v := range valid {
+		for j, x := range v {
+			st.MeanVector[j] += x
+		}
+	}
+	for j := range st.MeanVector {
+		st.MeanVector[j] /= real(len(valid))
+	}
+	st.MeanVectorNorm = norm(st.MeanVector)
+
+	logln(infoLogLevel, "computing principal components")
+	st.PCVarianceRatios = pcVarianceRatios(valid, st.MeanVector, statsPrincipalComponents)
+
+	logln(infoLogLevel, "sampling random pairs")
+	var totalCos real
+	var pairs int
+	for k := 0; k < statsRandomPairs && len(valid) > 1; k++ {
+		i := randng.Intn(len(valid))
+		j := randng.Intn(len(valid))
+		if i == j {
+			continue
+		}
+		totalCos += cosine(valid[i], valid[j])
+		pairs++
+	}
+	if pairs > 0 {
+		st.RandomPairMeanCosine = totalCos / real(pairs)
+	}
+	writeVectorStats(st)
+}
+
+func writeVectorStats(st vectorStats) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	check(enc.Encode(st))
+}
+
+func computeNormStats(norms []real) normStats {
+	sorted := append([]real(nil), norms...)
+	sort.Float64s(sorted)
+	var ns normStats
+	ns.Min = sorted[0]
+	ns.Max = sorted[len(sorted)-1]
+	for _, n := range sorted {
+		ns.Mean += n
+	}
+	ns.Mean /= real(len(sorted))
+	for _, n := range sorted {
+		ns.Std += (n - ns.Mean) * (n - ns.Mean)
+	}
+	ns.Std = math.Sqrt(ns.Std / real(len(sorted)))
+	ns.Percentiles = make(map[string]real)
+	for _, p := range statsPercentiles {
+		ns.Percentiles[fmt.Sprintf("p%02.0f", p*100)] = sorted[int(p*real(len(sorted)-1))]
+	}
+	return ns
+}
+
+// pcVarianceRatios returns the fraction of the total variance explained by
+// each of the top k principal components. Isotropic vectors have a flat
+// spectrum.
+func pcVarianceRatios(vecs [][]real, mean []real, k int) []real {
+	d := len(mean)
+	covs := make([][]real, numThreads)
+	var wg sync.WaitGroup
+	for threadID := 0; threadID < numThreads; threadID++ {
+		wg.Add(1)
+		go func(threadID int) {
+			defer wg.Done()
+			cov := make([]real, d*d)
+			centered := make([]real, d)
+			for i := threadID; i < len(vecs); i += numThreads {
+				for j, x := range vecs[i] {
+					centered[j] = x - mean[j]
+				}
+				for j, x := range centered {
+					row := cov[j*d : (j+1)*d]
+					for l, y := range centered {
+						row[l] += x * y
+					}
+				}
+			}
+			covs[threadID] = cov
+		}(threadID)
+	}
+	wg.Wait()
+	cov := covs[0]
+	for _, c := range covs[1:] {
+		for i := range cov {
+			cov[i] += c[i]
+		}
+	}
+	// The covariance matrix is symmetric positive semi-definite, so its
+	// singular values are its eigenvalues.
+	_, eigenvalues, _ := svd(cov, d, d)
+	var total real
+	for _, e := range eigenvalues {
+		total += e
+	}
+	if k > d {
+		k = d
+	}
+	ratios := make([]real, k)
+	for i := range ratios {
+		if total > 0 {
+			ratios[i] = eigenvalues[i] / total
+		}
+	}
+	return ratios
+}
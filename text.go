@@ -26,65 +26,61 @@ import (
 	"bufio"
 	"io"
 	"math/rand"
-	"os"
-	"unicode/utf8"
+	"sync/atomic"
 )
 
-func createScanner(reader io.Reader) *bufio.Scanner {
-	var s = bufio.NewScanner(bufio.NewReader(reader))
-	s.Split(scanWords)
-	return s
+// tokenStream is satisfied by bufio.Scanner and tokenScanner.
+type tokenStream interface {
+	Scan() bool
+	Text() string
 }
 
-func openCorpus() *os.File {
-	if len(corpusPath) == 0 {
-		logln(errorLogLevel, "FATAL ERROR: corpus is a required argument")
-		os.Exit(1)
-	}
-	corpus, err := os.Open(corpusPath)
-	check(err)
-	return corpus
+// tokenScanner returns the normalized tokens of the corpus tokenizer.
+type tokenScanner struct {
+	s   *bufio.Scanner
+	t   tokenizer
+	tok string
 }
 
-func scanWords(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	// Skip leading spaces.
-	start := 0
-	for width := 0; start < len(data); start += width {
-		var r rune
-		r, width = utf8.DecodeRune(data[start:])
-		if r == '\n' || r == '.' {
-			return start + width, ctxbreakbytes, nil
-		}
-		if !isSpace(r) {
-			break
-		}
+func createScanner(reader io.Reader) tokenStream {
+	var s = bufio.NewScanner(bufio.NewReader(reader))
+	s.Split(corpusTokenizer.split)
+	var ts tokenStream = &tokenScanner{s: s, t: corpusTokenizer}
+	for _, phrases := range corpusPhrases {
+		ts = newPhraseMerger(ts, phrases)
 	}
-	// Scan until space, marking end of word.
-	for width, i := 0, start; i < len(data); i += width {
-		var r rune
-		r, width = utf8.DecodeRune(data[i:])
-		if isSpace(r) {
-			if r == '\n' || r == '.' {
-				width = 0
+	return ts
+}
+
+func (s *tokenScanner) Scan() bool {
+	for s.s.Scan() {
+		tok := s.s.Text()
+		if tok != ctxBreakToken {
+			if tok = s.t.normalize(tok); tok == "" {
+				continue
 			}
-			return i + width, data[start:i], nil
 		}
+		s.tok = tok
+		return true
 	}
-	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
-	if atEOF && len(data) > start {
-		return len(data), data[start:], nil
-	}
-	// Request more data.
-	return start, nil, nil
+	return false
 }
 
-// Identical to stdlib but allows treating periods as whitespace
+func (s *tokenScanner) Text() string {
+	return s.tok
+}
+
+// createCountsScanner splits the "word count" lines of vocab and totals files.
+func createCountsScanner(reader io.Reader) *bufio.Scanner {
+	var s = bufio.NewScanner(bufio.NewReader(reader))
+	s.Split(bufio.ScanLines)
+	return s
+}
+
+// Identical to stdlib
 func isSpace(r rune) bool {
 	if r <= '\u00FF' {
 		// Obvious ASCII ones: \t through \r plus space. Plus two Latin-1 oddballs.
-		if periodIsWhitespace && r == '.' {
-			return true
-		}
 		switch r {
 		case ' ', '\t', '\n', '\v', '\f', '\r':
 			return true
@@ -104,10 +100,33 @@ func isSpace(r rune) bool {
 	return false
 }
 
+// logSentenceSplits reports how many sentences windower split since the last
+// call.
+func logSentenceSplits() {
+	n := atomic.SwapUint64(&sentenceSplits, 0)
+	logln(infoLogLevel, "split %d sentences longer than %d words", n, maxSentenceLen)
+}
+
+// windowerSplits returns how many times windower splits a sentence of length
+// words, given -maxsentencelen and -slidesentences.
+func windowerSplits(length int) uint64 {
+	if length <= maxSentenceLen {
+		return 0
+	}
+	if !slideSentences {
+		return uint64((length - 1) / maxSentenceLen)
+	}
+	// After the first split, each part adds maxSentenceLen - 2 * window words
+	// to the window words kept from the previous one.
+	return uint64((length-maxSentenceLen-1)/(maxSentenceLen-2*window)) + 1
+}
+
 type windowerCallback func(w, c *word, pos int) bool
 
-func windower(s *bufio.Scanner, randng *rand.Rand, includeTargetOnly bool, callback windowerCallback) {
+func windower(s tokenStream, randng *rand.Rand, includeTargetOnly bool, callback windowerCallback) {
 	var buf []*word
+	// Index of the first target in buf not yet processed.
+	var first int
 
 	// Iterate over every token in the corpus.
 	for s.Scan() {
@@ -131,9 +150,20 @@ func windower(s *bufio.Scanner, randng *rand.Rand, includeTargetOnly bool, callb
 
 		// If we hit a context break (newline or period) or the buffer is full,
 		// run window over the buffer.
-		if mapw == ctxbreakw || len(buf) == maxSentenceLen {
+		split := mapw != ctxbreakw && len(buf) == maxSentenceLen
+		if mapw == ctxbreakw || split {
+			// When sliding, the last window targets of a split sentence are
+			// left for the next buffer, where their right contexts will be.
+			last := len(buf)
+			if split {
+				atomic.AddUint64(&sentenceSplits, 1)
+				if slideSentences {
+					last -= window
+				}
+			}
 			// j is the position of target word within sliding window.
-			for j, target := range buf {
+			for j := first; j < last; j++ {
+				target := buf[j]
 				win := window
 				// If we are using weighted window like word2vec, uniformly sample window size.
 				if weightedWindow {
@@ -163,8 +193,10 @@ func windower(s *bufio.Scanner, randng *rand.Rand, includeTargetOnly bool, callb
 						posW = mapc.posW(pos)
 					}
 					mapc, ok = ctxVocab[posW]
+					// Skip words excluded from the context vocab by
+					// -ctxmaxvocab or -ctxminfreq.
 					if !ok {
-						logln(errorLogLevel, "ctx word %s not in vocab", posW)
+						continue
 					}
 
 					if !callback(target, mapc, pos) {
@@ -177,8 +209,16 @@ func windower(s *bufio.Scanner, randng *rand.Rand, includeTargetOnly bool, callb
 					}
 				}
 			}
-			// Clear the buffer so we can process the next sentence.
-			buf = nil
+			if split && slideSentences {
+				// Keep the processed targets within a window of the remaining
+				// ones as their left contexts.
+				buf = append(buf[:0], buf[len(buf)-2*window:]...)
+				first = window
+			} else {
+				// Clear the buffer so we can process the next sentence.
+				buf = nil
+				first = 0
+			}
 		}
 
 		// If the current target word is not a context break, push it into the buffer.
@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const tokenizerPathSuffix = ".tokenizer"
+
+// Tokens replacing words matched by normalization rules.
+const (
+	urlToken    = "<url>"
+	emailToken  = "<email>"
+	handleToken = "<handle>"
+)
+
+// tokenizer splits corpus text into the tokens that are counted and windowed.
+type tokenizer interface {
+	// split is a bufio.SplitFunc returning words, and ctxbreakbytes at
+	// context breaks.
+	split(data []byte, atEOF bool) (advance int, token []byte, err error)
+	// normalize maps a word returned by split to its vocab form, or to "" if
+	// the word is to be dropped.
+	normalize(w string) string
+}
+
+// tokenizerSettings are the options that determine how a corpus is
+// tokenized. They are saved next to the vocab so that commands reading the
+// vocab tokenize the corpus the same way it was built.
+type tokenizerSettings struct {
+	Name               string `json:"tokenizer"`
+	BreakChars         string `json:"breakchars"`
+	PeriodIsWhitespace bool   `json:"periodiswhitespace"`
+	Lowercase          bool   `json:"lowercase"`
+	SplitPunct         bool   `json:"splitpunct"`
+	MaxTokenLen        int    `json:"maxtokenlen"`
+	SegmentChars       int    `json:"segmentchars"`
+	NormDigits         bool   `json:"normdigits"`
+	NormURLs           bool   `json:"normurls"`
+	NormEmails         bool   `json:"normemails"`
+	NormHandles        bool   `json:"normhandles"`
+	PhrasesSHA256      string `json:"phrasessha256"`
+}
+
+var tokenizerMap = map[string]func(s tokenizerSettings) tokenizer{
+	// Newlines and periods preceded by whitespace are context breaks.
+	"default": func(s tokenizerSettings) tokenizer { return newBreakTokenizer(s, ".") },
+	// Only newlines are context breaks.
+	"whitespace": func(s tokenizerSettings) tokenizer { return newBreakTokenizer(s, "") },
+}
+
+func tokenizerNames() []string {
+	var names []string
+	for name := range tokenizerMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTokenizer(s tokenizerSettings) tokenizer {
+	newFunc, ok := tokenizerMap[s.Name]
+	if !ok {
+		logln(errorLogLevel, "invalid option for -tokenizer")
+	}
+	if s.SegmentChars < 0 {
+		logln(errorLogLevel, "segmentchars must be 0 or greater")
+	}
+	if s.MaxTokenLen < 0 {
+		logln(errorLogLevel, "maxtokenlen must be 0 or greater")
+	}
+	return newFunc(s)
+}
+
+func (s tokenizerSettings) String() string {
+	b, err := json.Marshal(s)
+	check(err)
+	return string(b)
+}
+
+func saveTokenizerSettings(path string) {
+	check(ioutil.WriteFile(path, []byte(tokenization.String()+"\n"), 0644))
+}
+
+// checkTokenizerSettings fails if the settings saved at path differ from the
+// current ones. Vocabs built before settings were saved are accepted.
+func checkTokenizerSettings(path string) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logln(infoLogLevel, "%s not found, can't check tokenizer settings", path)
+		return
+	}
+	check(err)
+	var saved tokenizerSettings
+	check(json.Unmarshal(b, &saved))
+	if saved != tokenization {
+		logln(errorLogLevel, "%s was built with tokenizer settings %s but current settings are %s", strings.TrimSuffix(path, tokenizerPathSuffix), saved, tokenization)
+	}
+}
+
+// vocabForm returns w as the corpus tokenizer would have added it to the
+// vocab (e.g. lowercased), or w itself if the tokenizer would drop it.
+func vocabForm(w string) string {
+	if normalized := corpusTokenizer.normalize(w); normalized != "" {
+		return normalized
+	}
+	return w
+}
+
+// breakTokenizer splits words at whitespace. Runes in breaks are context
+// breaks when not part of a word, -breakchars runes are context breaks that
+// also end words.
+type breakTokenizer struct {
+	breaks      string
+	spaces      string
+	splitPunct  bool
+	lowercase   bool
+	maxTokenLen int
+	// Length of character n-grams emitted for scripts written without
+	// spaces, 0 to split them at whitespace like other scripts.
+	segmentChars int
+	normDigits   bool
+	normURLs     bool
+	normEmails   bool
+	normHandles  bool
+}
+
+func newBreakTokenizer(s tokenizerSettings, breaks string) *breakTokenizer {
+	t := &breakTokenizer{
+		breaks:       breaks + s.BreakChars,
+		spaces:       s.BreakChars,
+		splitPunct:   s.SplitPunct,
+		lowercase:    s.Lowercase,
+		maxTokenLen:  s.MaxTokenLen,
+		segmentChars: s.SegmentChars,
+		normDigits:   s.NormDigits,
+		normURLs:     s.NormURLs,
+		normEmails:   s.NormEmails,
+		normHandles:  s.NormHandles,
+	}
+	if s.PeriodIsWhitespace {
+		t.spaces += "."
+	}
+	return t
+}
+
+func (t *breakTokenizer) isBreak(r rune) bool {
+	return r == '\n' || strings.ContainsRune(t.breaks, r)
+}
+
+func (t *breakTokenizer) isSpace(r rune) bool {
+	return isSpace(r) || strings.ContainsRune(t.spaces, r)
+}
+
+func (t *breakTokenizer) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	// Skip leading spaces.
+	start := 0
+	for width := 0; start < len(data); start += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[start:])
+		if t.isBreak(r) {
+			return start + width, ctxbreakbytes, nil
+		}
+		if !t.isSpace(r) {
+			if t.splitPunct && unicode.IsPunct(r) {
+				return start + width, data[start : start+width], nil
+			}
+			if t.segmentChars > 0 && isSegmented(r) {
+				return t.splitSegmented(data, atEOF, start)
+			}
+			break
+		}
+	}
+	// Scan until space, marking end of word.
+	for width, i := 0, start; i < len(data); i += width {
+		var r rune
+		r, width = utf8.DecodeRune(data[i:])
+		if t.isSpace(r) {
+			if t.isBreak(r) {
+				width = 0
+			}
+			return i + width, data[start:i], nil
+		}
+		if (t.splitPunct && unicode.IsPunct(r)) || (t.segmentChars > 0 && isSegmented(r)) {
+			return i, data[start:i], nil
+		}
+	}
+	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+	// Request more data.
+	return start, nil, nil
+}
+
+// isSegmented reports whether r belongs to a script written without spaces
+// between words. The prolonged sound mark and iteration mark are in the Common
+// script but only used within Japanese and Chinese words.
+func isSegmented(r rune) bool {
+	return r == '\u30fc' || r == '\u3005' || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai)
+}
+
+// splitSegmented returns the character n-gram starting at data[start], which
+// is within a run of segmented runes. Consecutive n-grams overlap, and a run
+// shorter than n is returned whole.
+func (t *breakTokenizer) splitSegmented(data []byte, atEOF bool, start int) (advance int, token []byte, err error) {
+	end, firstWidth := start, 0
+	for n := 0; n <= t.segmentChars; n++ {
+		if !atEOF && !utf8.FullRune(data[end:]) {
+			// Request more data.
+			return start, nil, nil
+		}
+		if end == len(data) {
+			break
+		}
+		r, width := utf8.DecodeRune(data[end:])
+		if !isSegmented(r) {
+			break
+		}
+		if n == t.segmentChars {
+			// The run continues after this n-gram, so the next one starts at
+			// the following rune.
+			return start + firstWidth, data[start:end], nil
+		}
+		if n == 0 {
+			firstWidth = width
+		}
+		end += width
+	}
+	return end, data[start:end], nil
+}
+
+func (t *breakTokenizer) normalize(w string) string {
+	switch {
+	case t.normURLs && isURL(w):
+		return urlToken
+	case t.normEmails && isEmail(w):
+		return emailToken
+	case t.normHandles && isHandle(w):
+		return handleToken
+	}
+	if t.maxTokenLen > 0 && utf8.RuneCountInString(w) > t.maxTokenLen {
+		return ""
+	}
+	if t.lowercase {
+		w = strings.ToLower(w)
+	}
+	if t.normDigits {
+		w = digitShape(w)
+	}
+	return w
+}
+
+func isURL(w string) bool {
+	lower := strings.ToLower(w)
+	for _, prefix := range []string{"http://", "https://", "ftp://", "www."} {
+		if strings.HasPrefix(lower, prefix) && len(lower) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmail reports whether w looks like user@domain.tld.
+func isEmail(w string) bool {
+	at := strings.IndexByte(w, '@')
+	if at < 1 || strings.IndexByte(w[at+1:], '@') >= 0 {
+		return false
+	}
+	dot := strings.LastIndexByte(w, '.')
+	return dot > at+1 && dot < len(w)-1
+}
+
+// isHandle reports whether w is an @handle of letters, digits and underscores.
+func isHandle(w string) bool {
+	if len(w) < 2 || w[0] != '@' {
+		return false
+	}
+	for _, r := range w[1:] {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// digitShape replaces each digit of w with '#', so that "1999" becomes "####".
+func digitShape(w string) string {
+	if strings.IndexFunc(w, unicode.IsDigit) < 0 {
+		return w
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return '#'
+		}
+		return r
+	}, w)
+}
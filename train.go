@@ -24,6 +24,8 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"math"
 	"math/rand"
 	"os"
@@ -60,6 +62,10 @@ var alpha real
 var totalDatum uint64
 var iteration int
 
+// SGD threads hold trainLock for reading while stepping so that evaluation
+// can pause them by holding it for writing.
+var trainLock sync.RWMutex
+
 func train(it trainIterator) {
 	if len(vectorOutputPath) == 0 {
 		logln(errorLogLevel, "FATAL ERROR: output (where to save vectors) is a required argument")
@@ -74,6 +80,9 @@ func train(it trainIterator) {
 	totalDatum = it.totalDatum()
 
 	sgdStepCgoInit()
+	initHeldOut()
+
+	evaluator := newTrainEvaluator()
 
 	// This is used to wait on SGD threads until all are complete.
 	var wg sync.WaitGroup
@@ -84,19 +93,37 @@ func train(it trainIterator) {
 			numLossesPerThread[i] = 0
 			datumProcessedPerThread[i] = 0
 		}
+		resetHeldOut()
 		for threadID := 0; threadID < numThreads; threadID++ {
 			wg.Add(1)
 			go trainThread(&wg, threadID, iteration, it)
 		}
 		quitProgressReport := make(chan bool)
-		go progressReport(quitProgressReport)
+		go progressReport(quitProgressReport, evaluator)
 		wg.Wait()
 		quitProgressReport <- true
 		logln(infoLogLevel, "iteration %d MSE = %f, sgdsteps %d", iteration, meanLoss(), numLosses())
+		if _, ok := it.(*trainIteratorIM); ok {
+			// Splits are counted again by each iteration over the corpus.
+			logSentenceSplits()
+		}
+		if holdout > 0 {
+			loss, samples := heldOutLoss()
+			logln(infoLogLevel, "iteration %d held-out MSE = %f, samples %d", iteration, loss, samples)
+		}
+		if evaluator != nil && evalEvery == 0 {
+			evaluator.evaluate(fmt.Sprintf("iteration %d", iteration))
+		}
+	}
+	if evaluator != nil {
+		if evalEvery > 0 {
+			evaluator.evaluate("end")
+		}
+		evaluator.restoreBest()
 	}
 }
 
-func progressReport(quit chan bool) {
+func progressReport(quit chan bool, evaluator *trainEvaluator) {
 	startAt := time.Now()
 	for {
 		select {
@@ -104,6 +131,11 @@ func progressReport(quit chan bool) {
 			return
 		default:
 			time.Sleep(time.Second)
+			if evaluator != nil && evaluator.due() {
+				trainLock.Lock()
+				evaluator.evaluate(fmt.Sprintf("%.1f%%", progress()*1e2))
+				trainLock.Unlock()
+			}
 			secondsElapsed := time.Now().Sub(startAt).Seconds()
 			pairsPerSecond := real(numLosses()) / real(numThreads) / secondsElapsed
 			currentTotalDatumProcessed := totalDatumProcessed()
@@ -161,7 +193,9 @@ func trainThread(wg *sync.WaitGroup, threadID, iteration int, it trainIterator)
 
 	step := func() {
 		numLossesPerThread[threadID] += uint64(n)
+		trainLock.RLock()
 		totalLossPerThread[threadID] += sgdStepCgoBatch(wIdxs, cIdxs, ys, zVec, alpha, n)
+		trainLock.RUnlock()
 
 		alpha = initialAlpha * (1 - progress())
 		if alpha < initialAlpha*0.0001 {
@@ -178,6 +212,10 @@ func trainThread(wg *sync.WaitGroup, threadID, iteration int, it trainIterator)
 		if !processStrategy(y) {
 			return
 		}
+		if holdout > 0 && isHeldOut(w.idx, c.idx) {
+			holdOut(threadID, w, c.idx, y)
+			return
+		}
 		wIdxs[n] = w.idx
 		cIdxs[n] = c.idx
 		ys[n] = y
@@ -205,6 +243,9 @@ func newTrainIteratorEM() *trainIteratorEM {
 	coocStat, err := os.Stat(coocPath)
 	check(err)
 	coocStreamFileSize := coocStat.Size()
+	if coocStreamFileSize%12 != 0 {
+		logln(errorLogLevel, "%s is not a whole number of cooc records, run lexvec verify", coocPath)
+	}
 	lines := coocStreamFileSize / 12
 	return &trainIteratorEM{lines}
 }
@@ -244,16 +285,21 @@ func (t *trainIteratorEM) iterate(threadID int, callback trainIteratorCallback)
 type trainIteratorIM struct {
 	corpusFileSize  int64
 	randngPerThread []*rand.Rand
+
+	// Compressed corpora can't be split by seeking, so a single reader hands
+	// out chunks to threads instead.
+	chunked         bool
+	chunksLock      sync.Mutex
+	chunksIteration int
+	chunks          chan corpusChunk
 }
 
 func newTrainIteratorIM() *trainIteratorIM {
-	corpusStat, err := os.Stat(corpusPath)
-	check(err)
 	var randngPerThread []*rand.Rand
 	for threadID := 0; threadID < numThreads; threadID++ {
 		randngPerThread = append(randngPerThread, rand.New(rand.NewSource(int64(threadID))))
 	}
-	return &trainIteratorIM{corpusStat.Size(), randngPerThread}
+	return &trainIteratorIM{corpusFileSize: corpusFilesSize(), randngPerThread: randngPerThread, chunked: corpusIsCompressed(), chunksIteration: -1}
 }
 
 func (t *trainIteratorIM) totalDatum() uint64 {
@@ -266,53 +312,90 @@ func (t *trainIteratorIM) iterate(threadID int, callback trainIteratorCallback)
 	// Each thread needs its own random number generator
 	randn := t.randngPerThread[threadID]
 
-	// Each thread needs handle to corpus
-	corpus := openCorpus()
-	defer corpus.Close()
+	if t.chunked {
+		t.iterateChunks(randn, callback)
+		return
+	}
 
-	// Determine thread's segment within file.
+	// Determine thread's segment within the concatenated corpus files.
 	corpusOffsetStart := (t.corpusFileSize / int64(numThreads)) * int64(threadID)
 	corpusOffsetEnd := (t.corpusFileSize / int64(numThreads)) * int64(threadID+1)
 	if corpusOffsetEnd >= t.corpusFileSize {
 		corpusOffsetEnd = t.corpusFileSize
 	}
-	_, err := corpus.Seek(corpusOffsetStart, 0)
-	check(err)
+
+	// Each thread needs handle to corpus
+	corpus := openCorpusAt(corpusOffsetStart)
+	defer corpus.Close()
 
 	s := createScanner(corpus)
 
 	// Iterate over every token in the corpus within thread's segment.
 	windower(s, randn, true, func(target, mapc *word, pos int) bool {
-		// This funky Seek call is to get current position within file.
-		curPos, err := corpus.Seek(0, 1)
-		check(err)
+		// Current position within the concatenated files.
+		curPos := corpusOffsetStart + corpus.rawBytesRead()
 
 		// Check to see if overstepping segment. If so done with iteration.
 		if curPos > corpusOffsetEnd {
 			return false
 		}
 
-		datumProcessedByThread := uint64(curPos - corpusOffsetStart)
-		if mapc != nil {
-			callback(target, mapc, associationMeasure(target, mapc, coocStorage.get(target.idx, mapc.idx)), datumProcessedByThread)
-		} else {
-			for k := 0; k < negative; k++ {
-				// Draw negative sample.
-				mapc := noiseSampler.sample(randn)
-
-				// Skip if sample is target word.
-				if target == mapc {
-					continue
-				}
-
-				// Draw until sample is not context break.
-				for mapc == ctxbreakw {
-					mapc = noiseSampler.sample(randn)
-				}
-
-				callback(target, mapc, associationMeasure(target, mapc, coocStorage.get(target.idx, mapc.idx)), datumProcessedByThread)
-			}
-		}
+		t.emit(randn, target, mapc, uint64(curPos-corpusOffsetStart), callback)
 		return true
 	})
 }
+
+// iterateChunks processes chunks until the corpus is exhausted. The datum
+// processed by the thread are the compressed bytes behind its chunks.
+func (t *trainIteratorIM) iterateChunks(randn *rand.Rand, callback trainIteratorCallback) {
+	var datumProcessedByThread uint64
+	for chunk := range t.iterationChunks() {
+		r := bytes.NewReader(chunk.data)
+		s := createScanner(r)
+		windower(s, randn, true, func(target, mapc *word, pos int) bool {
+			// Attribute compressed bytes in proportion to the chunk consumed.
+			consumed := uint64(chunk.rawBytes) * uint64(len(chunk.data)-r.Len()) / uint64(len(chunk.data))
+			t.emit(randn, target, mapc, datumProcessedByThread+consumed, callback)
+			return true
+		})
+		datumProcessedByThread += uint64(chunk.rawBytes)
+	}
+}
+
+// iterationChunks returns the chunks of the current iteration, starting the
+// reader when the first thread asks for them.
+func (t *trainIteratorIM) iterationChunks() chan corpusChunk {
+	t.chunksLock.Lock()
+	defer t.chunksLock.Unlock()
+	if t.chunksIteration != iteration {
+		t.chunks = make(chan corpusChunk, 2*numThreads)
+		go readCorpusChunks(t.chunks)
+		t.chunksIteration = iteration
+	}
+	return t.chunks
+}
+
+// emit calls callback with the positive cell (target, mapc), or with negative
+// samples for target when mapc is nil.
+func (t *trainIteratorIM) emit(randn *rand.Rand, target, mapc *word, datumProcessedByThread uint64, callback trainIteratorCallback) {
+	if mapc != nil {
+		callback(target, mapc, associationMeasure(target, mapc, coocStorage.get(target.idx, mapc.idx)), datumProcessedByThread)
+		return
+	}
+	for k := 0; k < negative; k++ {
+		// Draw negative sample.
+		mapc := noiseSampler.sample(randn)
+
+		// Skip if sample is target word.
+		if target == mapc {
+			continue
+		}
+
+		// Draw until sample is not context break.
+		for mapc == ctxbreakw {
+			mapc = noiseSampler.sample(randn)
+		}
+
+		callback(target, mapc, associationMeasure(target, mapc, coocStorage.get(target.idx, mapc.idx)), datumProcessedByThread)
+	}
+}
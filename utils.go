@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 )
 
 // Helper for aborting on error.
@@ -35,9 +36,17 @@ func check(e error) {
 	}
 }
 
+var errCountOverflow = errors.New("overflow in countUint, change type countUint = uint32 to countUint = uint64")
+
 func checkCountIncOverflow(v countUint) {
 	if v == 0 {
-		panic(errors.New("overflow in countUint, change type countUint = uint32 to countUint = uint64"))
+		panic(errCountOverflow)
+	}
+}
+
+func checkCountAddOverflow(v, n countUint) {
+	if v+n < v {
+		panic(errCountOverflow)
 	}
 }
 
@@ -81,3 +90,19 @@ func (p *progressPrinter) inc() {
 		log(infoLogLevel, "\r%dK", p.n/1000)
 	}
 }
+
+// stringList is a flag that can be repeated and/or given comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if len(s) > 0 {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
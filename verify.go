@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2016 Salle, Alexandre <alex@alexsalle.com>
+ * Author: Salle, Alexandre <alex@alexsalle.com>
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of
+ * this software and associated documentation files (the "Software"), to deal in
+ * the Software without restriction, including without limitation the rights to
+ * use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+ * the Software, and to permit persons to whom the Software is furnished to do so,
+ * subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+ * FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+ * COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+ * IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+ * CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+const maxReportedProblems = 20
+
+type artifactVerifier struct {
+	problems int
+}
+
+func (v *artifactVerifier) problem(msg string, args ...interface{}) {
+	v.problems++
+	if v.problems <= maxReportedProblems {
+		logln(infoLogLevel, "PROBLEM: "+msg, args...)
+	} else if v.problems == maxReportedProblems+1 {
+		logln(infoLogLevel, "too many problems, only counting from now on")
+	}
+}
+
+type coocCell struct {
+	cooc    idxUint
+	records idxUint
+}
+
+// verifyArtifacts checks that the vocab, context vocab, coocs and cooc totals
+// files given by -vocab, -coocpath and -cooctotalspath were produced together
+// (with the -window and -pos given). Keeps every distinct cell of the cooc
+// file in memory.
+func verifyArtifacts() {
+	if len(vocabPath) == 0 || len(coocPath) == 0 || len(coocTotalsPath) == 0 {
+		logln(errorLogLevel, "FATAL ERROR: vocab, coocpath and cooctotalspath are required arguments")
+	}
+	v := &artifactVerifier{}
+
+	logln(infoLogLevel, "reading vocab")
+	vocabList, vocab := readVocabFile(vocabPath)
+	logln(infoLogLevel, "reading context vocab")
+	ctxVocabList, _ := readVocabFile(vocabPath + contextPathSuffix)
+	if _, ok := vocab[ctxBreakToken]; !ok {
+		v.problem("ctxbreak %s not in vocab", ctxBreakToken)
+	}
+	ctxWords := contextWords(vocabList)
+	expectedCtxVocabSize := len(ctxWords)
+	if positionalContexts {
+		expectedCtxVocabSize *= 2 * window
+	}
+	if len(ctxVocabList) != expectedCtxVocabSize {
+		v.problem("context vocab has %d words, expected %d for %d context words with -window %d -pos %t -ctxmaxvocab %d -ctxminfreq %d", len(ctxVocabList), expectedCtxVocabSize, len(ctxWords), window, positionalContexts, ctxMaxVocab, ctxMinFreq)
+	}
+
+	coocStat, err := os.Stat(coocPath)
+	check(err)
+	if coocStat.Size()%12 != 0 {
+		v.problem("%s has %d bytes, which is not a multiple of the 12 byte record size", coocPath, coocStat.Size())
+	}
+	records := coocStat.Size() / 12
+	logln(infoLogLevel, "reading %d cooc records", records)
+
+	coocStream, err := os.Open(coocPath)
+	check(err)
+	defer coocStream.Close()
+	r := bufio.NewReader(coocStream)
+	b := make([]byte, 16)
+	cells := make(map[uint64]*coocCell)
+	pp := newProgressPrinter(defaultProgressInterval)
+	for i := int64(0); i < records; i++ {
+		pp.inc()
+		l, err := readCoocLine(r, b, true)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		check(err)
+		if int(l.wIdx) >= len(vocabList) || int(l.cIdx) >= len(ctxVocabList) {
+			v.problem("record %d (%d, %d) out of bounds of vocab (%d) or context vocab (%d)", i, l.wIdx, l.cIdx, len(vocabList), len(ctxVocabList))
+			continue
+		}
+		key := uint64(l.wIdx)<<32 | uint64(l.cIdx)
+		cell, ok := cells[key]
+		if !ok {
+			cell = &coocCell{cooc: l.cooc}
+			cells[key] = cell
+		} else if cell.cooc != l.cooc {
+			v.problem("record %d (%s, %s) has cooc %d, earlier records of the cell have %d", i, vocabList[l.wIdx].w, ctxVocabList[l.cIdx].w, l.cooc, cell.cooc)
+		}
+		cell.records++
+	}
+
+	// Each cell is repeated once for each time it was drawn, positively or as
+	// a negative sample, and every copy stores the total positive count.
+	wTotals := make([]uint64, len(vocabList))
+	cTotals := make([]uint64, len(ctxVocabList))
+	var posRecords, negRecords uint64
+	for key, cell := range cells {
+		if cell.cooc > cell.records {
+			v.problem("cell (%s, %s) has cooc %d but only %d records", vocabList[key>>32].w, ctxVocabList[idxUint(key)].w, cell.cooc, cell.records)
+			continue
+		}
+		wTotals[key>>32] += uint64(cell.cooc)
+		cTotals[idxUint(key)] += uint64(cell.cooc)
+		posRecords += uint64(cell.cooc)
+		negRecords += uint64(cell.records - cell.cooc)
+	}
+	logln(infoLogLevel, "%d distinct cells, %d positive and %d negative sample records, %.3f negative per positive",
+		len(cells), posRecords, negRecords, real(negRecords)/real(posRecords))
+
+	v.verifyCoocTotals(coocTotalsPath, vocabList, wTotals)
+	v.verifyCoocTotals(coocTotalsPath+contextPathSuffix, ctxVocabList, cTotals)
+
+	if v.problems > 0 {
+		logln(errorLogLevel, "verification failed with %d problems", v.problems)
+	}
+	logln(infoLogLevel, "all artifacts are consistent")
+}
+
+// verifyCoocTotals checks that the totals file lists the words of vocabList,
+// in order, with the totals recomputed from the coocs.
+func (v *artifactVerifier) verifyCoocTotals(path string, vocabList []*word, totals []uint64) {
+	logln(infoLogLevel, "verifying %s", path)
+	coocTotalsStream, err := os.Open(path)
+	check(err)
+	defer coocTotalsStream.Close()
+	var i int
+	readCounts(createCountsScanner(coocTotalsStream), func(w string, cnt countUint) {
+		if i >= len(vocabList) {
+			v.problem("%s has more words than vocab (%d)", path, len(vocabList))
+		} else if vocabList[i].w != w {
+			v.problem("%s line %d is %s, vocab has %s", path, i+1, w, vocabList[i].w)
+		} else if uint64(cnt) != totals[i] {
+			v.problem("%s total of %s is %d, coocs sum to %d", path, w, cnt, totals[i])
+		}
+		i++
+	})
+	if i < len(vocabList) {
+		v.problem("%s has %d words, vocab has %d", path, i, len(vocabList))
+	}
+}
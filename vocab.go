@@ -25,11 +25,16 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"hash/fnv"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/alexandres/lexvec/embedding"
 )
 
 const (
@@ -62,19 +67,20 @@ type countCallback func(w string, cnt countUint)
 
 func readCounts(s *bufio.Scanner, callback countCallback) {
 	for s.Scan() {
-		w := s.Text()
-		s.Scan()
-		freq, err := strconv.ParseUint(s.Text(), 10, 32)
+		parts := strings.Fields(s.Text())
+		if len(parts) != 2 {
+			logln(errorLogLevel, "bad count line: %s", s.Text())
+		}
+		freq, err := strconv.ParseUint(parts[1], 10, 32)
 		check(err)
-		callback(w, countUint(freq))
-		s.Scan()
+		callback(parts[0], countUint(freq))
 	}
 }
 
 func readVocabFile(path string) ([]*word, map[string]*word) {
 	vocabFile, err := os.Open(path)
 	check(err)
-	s := createScanner(vocabFile)
+	s := createCountsScanner(vocabFile)
 	var vocabList []*word
 	vocab := make(map[string]*word)
 	readCounts(s, func(w string, freq countUint) {
@@ -90,6 +96,7 @@ func readVocab() {
 	vocabList, vocab = readVocabFile(vocabPath)
 	logln(infoLogLevel, "reading context vocab")
 	ctxVocabList, ctxVocab = readVocabFile(vocabPath + contextPathSuffix)
+	checkTokenizerSettings(vocabPath + tokenizerPathSuffix)
 
 	vocabSize = idxUint(len(vocabList))
 	ctxVocabSize = idxUint(len(ctxVocabList))
@@ -112,40 +119,290 @@ func readVocab() {
 
 }
 
-func buildVocab() {
-	// Didn't supply a vocabulary file. Will go over corpus to find words and their counts.
-	tmpVocab := make(map[string]*word)
-	logln(infoLogLevel, "build vocab")
+// typeCounter counts the tokens of each type. If maxTypes > 0, it bounds
+// memory by pruning the rarest types whenever more than maxTypes are held,
+// like word2vec's ReduceVocab, at the cost of undercounting.
+type typeCounter struct {
+	vocab     map[string]*word
+	list      []*word
+	maxTypes  int
+	threshold countUint
+	// undercount bounds how much lower than its true count the count of any
+	// type is, including types pruned and never seen again.
+	undercount countUint
+	prunes     int
+}
+
+func newTypeCounter(maxTypes int) *typeCounter {
+	return &typeCounter{vocab: make(map[string]*word), maxTypes: maxTypes, threshold: 1}
+}
+
+// add counts tok, returning its word. Until the counter is pruned, words are
+// indexed in order of first appearance.
+func (c *typeCounter) add(tok string) *word {
+	return c.addN(tok, 1)
+}
+
+// addN counts n occurrences of tok.
+func (c *typeCounter) addN(tok string, n countUint) *word {
+	w, ok := c.vocab[tok]
+	if !ok {
+		w = &word{tok, idxUint(len(c.list)), 0, 0, nil, 0}
+		c.list = append(c.list, w)
+		c.vocab[tok] = w
+	}
+	checkCountAddOverflow(w.freq, n)
+	w.freq += n
+	if c.maxTypes > 0 && len(c.list) > c.maxTypes {
+		c.prune()
+	}
+	return w
+}
+
+// prune removes the types counted at most threshold times, raising the
+// threshold after each pass, until at most maxTypes remain.
+func (c *typeCounter) prune() {
+	for len(c.list) > c.maxTypes {
+		kept := c.list[:0]
+		for _, w := range c.list {
+			if w.freq > c.threshold {
+				kept = append(kept, w)
+			} else {
+				delete(c.vocab, w.w)
+			}
+		}
+		for i := len(kept); i < len(c.list); i++ {
+			c.list[i] = nil
+		}
+		c.list = kept
+		c.undercount += c.threshold
+		c.threshold++
+		c.prunes++
+	}
+}
+
+// countTypes counts the tokens of the corpus in one thread, writing them to
+// spool if not nil.
+func countTypes(spool *tokenSpool) *typeCounter {
 	corpus := openCorpus()
 	defer corpus.Close()
 	s := createScanner(corpus)
+	counter := newTypeCounter(maxTypes)
 	pp := newProgressPrinter(defaultProgressInterval)
 	for s.Scan() {
 		pp.inc()
-		tok := s.Text()
-		w, ok := tmpVocab[tok]
-		if !ok {
-			w = &word{tok, idxUint(len(vocabList)), 0, 0, nil, 0}
-			vocabList = append(vocabList, w)
-			tmpVocab[tok] = w
+		w := counter.add(s.Text())
+		if spool != nil {
+			spool.write(w.idx)
 		}
-		w.freq++
-		checkCountIncOverflow(w.freq)
 
 		rawCorpusSize++
 	}
+	return counter
+}
+
+// countTypesInRanges counts the tokens of the corpus split into a range of
+// lines per thread, each with its own counter.
+func countTypesInRanges() *typeCounter {
+	size := corpusFilesSize()
+	counters := make([]*typeCounter, numThreads)
+	tokens := make([]uint64, numThreads)
+	var wg sync.WaitGroup
+	for threadID := 0; threadID < numThreads; threadID++ {
+		wg.Add(1)
+		go func(threadID int) {
+			defer wg.Done()
+			corpus := openCorpusRange(corpusRange(threadID, size))
+			defer corpus.Close()
+			s := createScanner(corpus)
+			counter := newTypeCounter(0)
+			for s.Scan() {
+				counter.add(s.Text())
+				tokens[threadID]++
+			}
+			counters[threadID] = counter
+		}(threadID)
+	}
+	wg.Wait()
+	// Merging in thread order keeps words in order of first appearance, so
+	// ties in frequency are sorted as when counting with one thread.
+	merged := newTypeCounter(0)
+	for threadID, counter := range counters {
+		for _, w := range counter.list {
+			merged.addN(w.w, w.freq)
+		}
+		rawCorpusSize += tokens[threadID]
+		counters[threadID] = nil
+	}
+	return merged
+}
+
+// countContextFreqsInRanges counts the (subsampled) occurrences of contexts
+// with a thread per range of lines, each thread with its own RNG seeded from
+// randng.
+func countContextFreqsInRanges() {
+	size := corpusFilesSize()
+	seeds := make([]int64, numThreads)
+	for threadID := range seeds {
+		seeds[threadID] = randng.Int63()
+	}
+	counts := make([][]countUint, numThreads)
+	var wg sync.WaitGroup
+	for threadID := 0; threadID < numThreads; threadID++ {
+		wg.Add(1)
+		go func(threadID int) {
+			defer wg.Done()
+			randn := rand.New(rand.NewSource(seeds[threadID]))
+			corpus := openCorpusRange(corpusRange(threadID, size))
+			defer corpus.Close()
+			threadCounts := make([]countUint, len(ctxVocabList))
+			windower(createScanner(corpus), randn, false, func(w, c *word, pos int) bool {
+				threadCounts[c.idx]++
+				checkCountIncOverflow(threadCounts[c.idx])
+				return true
+			})
+			counts[threadID] = threadCounts
+		}(threadID)
+	}
+	wg.Wait()
+	for _, threadCounts := range counts {
+		for i, cnt := range threadCounts {
+			checkCountAddOverflow(ctxVocabList[i].freq, cnt)
+			ctxVocabList[i].freq += cnt
+		}
+	}
+}
+
+// corpusIsSplittable reports whether the corpus can be read in ranges of lines
+// by several threads. Compressed corpora can't be split by seeking.
+func corpusIsSplittable() bool {
+	return numThreads > 1 && !corpusIsStdin() && !corpusIsCompressed()
+}
+
+// countVocab counts the tokens of the corpus, writing them to spool if not
+// nil.
+func countVocab(spool *tokenSpool) *typeCounter {
+	var counter *typeCounter
+	// Each thread would hold the distinct words of its range, so words are
+	// counted in one thread when their number is bounded by -maxtypes.
+	if spool == nil && maxTypes == 0 && corpusIsSplittable() {
+		logln(infoLogLevel, "counting words with %d threads", numThreads)
+		counter = countTypesInRanges()
+	} else {
+		counter = countTypes(spool)
+	}
+	if counter.prunes > 0 {
+		logln(infoLogLevel, "pruned rare words %d times to hold at most %d words, counts may be up to %d lower than their true counts (words occurring at least %d times are kept)", counter.prunes, maxTypes, counter.undercount, uint64(minFreq)+uint64(counter.undercount))
+	}
+	return counter
+}
+
+func buildVocab() {
+	// Didn't supply a vocabulary file. Will go over corpus to find words and their counts.
+	logln(infoLogLevel, "build vocab")
+	// stdin can't be read twice, so its tokens are spooled for the second pass.
+	var spool *tokenSpool
+	if corpusIsStdin() {
+		if maxTypes > 0 {
+			logln(errorLogLevel, "-maxtypes can't be used with a stdin corpus")
+		}
+		spool = newTokenSpool(filepath.Dir(vocabPath))
+		defer spool.remove()
+	}
+	vocabList = countVocab(spool).list
+	// Words in order of first appearance, as indexed in the spool.
+	firstSeen := append([]*word(nil), vocabList...)
+	selectVocab()
+	countContextFreqs(spool, firstSeen)
+}
+
+// countOnlyVocab writes the counts of all words of the corpus to -vocab, in
+// order of first appearance, to be merged with vocabmerge.
+func countOnlyVocab() {
+	logln(infoLogLevel, "counting words")
+	counter := countVocab(nil)
+	logln(infoLogLevel, "saving counts")
+	saveVocabFile(vocabPath, counter.list)
+	saveTokenizerSettings(vocabPath + tokenizerPathSuffix)
+}
+
+// mergeVocab builds the vocab from the counts written by vocab -countsonly
+// for each shard of the corpus, given in the order the shards are
+// concatenated in -corpus. -corpus is then read to count contexts.
+func mergeVocab(paths []string) {
+	if len(paths) == 0 {
+		logln(errorLogLevel, "no counts files given")
+	}
+	if len(corpusPath) == 0 {
+		logln(errorLogLevel, "vocabmerge reads -corpus to count contexts, which depend on the merged vocab")
+	}
+	counter := newTypeCounter(0)
+	for _, path := range paths {
+		logln(infoLogLevel, "reading counts %s", path)
+		checkTokenizerSettings(path + tokenizerPathSuffix)
+		f, err := os.Open(path)
+		check(err)
+		readCounts(createCountsScanner(f), func(w string, cnt countUint) {
+			counter.addN(w, cnt)
+			rawCorpusSize += uint64(cnt)
+		})
+		f.Close()
+	}
+	// Merging in shard order keeps words in order of first appearance, so
+	// ties in frequency are sorted as when counting the whole corpus.
+	vocabList = counter.list
+	selectVocab()
+	countContextFreqs(nil, nil)
+}
 
+// selectVocab sorts vocabList by frequency, keeping the words allowed by
+// -minfreq and -maxvocab, and builds the context vocab from it.
+func selectVocab() {
 	// Now sort the vocab by frequency and discard words if their frequency
-	// is below minFreq or cap vocab if its size exceeds maxVocab.
+	// is below minFreq or cap vocab if its size exceeds maxVocab. Words of
+	// -include are always kept, and those matching -exclude or -excluderegex
+	// are discarded.
 	sort.Sort(ByFreq(vocabList))
-	var cut idxUint
-	for ; cut < idxUint(len(vocabList)) && vocabList[cut].freq >= minFreq; cut++ {
-		corpusSize += uint64(vocabList[cut].freq)
+	include := readWordList(includePath)
+	exclude := readWordList(excludePath)
+	var excludeRe *regexp.Regexp
+	if len(excludeRegex) > 0 {
+		var err error
+		excludeRe, err = regexp.Compile(excludeRegex)
+		check(err)
+	}
+	kept := vocabList[:0]
+	var numIncluded, numExcluded int
+	for _, w := range vocabList {
+		if include[w.w] {
+			numIncluded++
+		} else if w.freq < minFreq {
+			continue
+		} else if w.w != ctxBreakToken && (exclude[w.w] || (excludeRe != nil && excludeRe.MatchString(w.w))) {
+			numExcluded++
+			continue
+		}
+		kept = append(kept, w)
+		corpusSize += uint64(w.freq)
+	}
+	if maxVocab > 0 && maxVocab < idxUint(len(kept)) {
+		// Included words take their slots from the least frequent others.
+		others := int(maxVocab) - numIncluded
+		cut := kept[:0]
+		for _, w := range kept {
+			if include[w.w] {
+				cut = append(cut, w)
+			} else if others > 0 {
+				cut = append(cut, w)
+				others--
+			}
+		}
+		kept = cut
 	}
-	if maxVocab > 0 && maxVocab < cut {
-		cut = maxVocab
+	if len(include) > 0 || numExcluded > 0 {
+		logln(infoLogLevel, "kept %d of %d words from -include, excluded %d words", numIncluded, len(include), numExcluded)
 	}
-	vocabList = vocabList[:cut]
+	vocabList = kept
 	// reindex and build definitive vocab
 	vocab = make(map[string]*word)
 	for i, w := range vocabList {
@@ -170,9 +427,10 @@ func buildVocab() {
 
 	// build context vocab
 	ctxVocab = make(map[string]*word)
+	ctxWords := contextWords(vocabList)
 	if positionalContexts {
 		logln(infoLogLevel, "creating positional vocab words")
-		for _, w := range vocabList {
+		for _, w := range ctxWords {
 			for j := -window; j <= window; j++ {
 				if j == 0 {
 					continue
@@ -185,24 +443,76 @@ func buildVocab() {
 		}
 	} else {
 		// If we are not using positional contexts, copy the sorted list of vocab words.
-		for _, w := range vocabList {
-			c := &word{w.w, w.idx, 0, 0, nil, 0}
+		for _, w := range ctxWords {
+			c := &word{w.w, idxUint(len(ctxVocabList)), 0, 0, nil, 0}
 			ctxVocabList = append(ctxVocabList, c)
 			ctxVocab[c.w] = c
 		}
 	}
+}
 
-	// get subsampled corpus freq for contexts. needed for accurate negative sampling
+// contextWords returns the words of vocabList, sorted by frequency, that are
+// used as contexts given -ctxminfreq and -ctxmaxvocab. The context break is
+// always kept.
+func contextWords(vocabList []*word) []*word {
+	var words []*word
+	var n idxUint
+	for _, w := range vocabList {
+		if w.w != ctxBreakToken {
+			if w.freq < ctxMinFreq || (ctxMaxVocab > 0 && n >= ctxMaxVocab) {
+				continue
+			}
+			n++
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// readWordList reads the set of words at the start of each line of path, if
+// given.
+func readWordList(path string) map[string]bool {
+	words := make(map[string]bool)
+	if len(path) == 0 {
+		return words
+	}
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if parts := strings.Fields(s.Text()); len(parts) > 0 {
+			words[parts[0]] = true
+		}
+	}
+	check(s.Err())
+	return words
+}
+
+// countContextFreqs gets the subsampled corpus freq for contexts, needed for
+// accurate negative sampling, reading the tokens from spool if not nil.
+func countContextFreqs(spool *tokenSpool, firstSeen []*word) {
 	logln(infoLogLevel, "getting ctx freq")
-	corpus.Seek(0, 0)
-	s = createScanner(corpus)
-	pp = newProgressPrinter(defaultProgressInterval)
-	windower(s, randng, false, func(w, c *word, pos int) bool {
-		pp.inc()
-		c.freq++
-		checkCountIncOverflow(c.freq)
-		return true
-	})
+	if spool == nil && corpusIsSplittable() {
+		countContextFreqsInRanges()
+	} else {
+		var s tokenStream
+		if spool != nil {
+			s = spool.scanner(firstSeen)
+		} else {
+			corpus := openCorpus()
+			defer corpus.Close()
+			s = createScanner(corpus)
+		}
+		pp := newProgressPrinter(defaultProgressInterval)
+		windower(s, randng, false, func(w, c *word, pos int) bool {
+			pp.inc()
+			c.freq++
+			checkCountIncOverflow(c.freq)
+			return true
+		})
+	}
+	logSentenceSplits()
 	// reindex ctx vocab
 	sort.Sort(ByFreq(ctxVocabList))
 	for i, w := range ctxVocabList {
@@ -227,6 +537,7 @@ func saveVocab() {
 	saveVocabFile(vocabPath, vocabList)
 	logln(infoLogLevel, "saving context vocab")
 	saveVocabFile(vocabPath+contextPathSuffix, ctxVocabList)
+	saveTokenizerSettings(vocabPath + tokenizerPathSuffix)
 }
 
 func buildSubwords() {
@@ -234,25 +545,12 @@ func buildSubwords() {
 		logln(errorLogLevel, "minn must be greater than 0 and less or equal to maxn")
 	}
 	for _, w := range vocabList {
-		for _, subword := range computeSubwords(w.w, subwordMinN, subwordMaxN) {
-			w.subwords = append(w.subwords, subwordIdx(subword, vocabSize, subwordMatrixRows-vocabSize))
+		for _, subword := range embedding.ComputeSubwords(w.w, subwordMinN, subwordMaxN) {
+			w.subwords = append(w.subwords, embedding.SubwordIdx(subword, vocabSize, subwordMatrixRows-vocabSize))
 		}
 	}
 }
 
-func computeSubwords(unwrappedw string, minn, maxn int) (subwords []string) {
-	w := fmt.Sprintf("<%s>", unwrappedw)
-	if len(w) < minn {
-		return
-	}
-	for i := 0; i <= len(w)-minn; i++ {
-		for l := minn; l < len(w) && l <= maxn && i+l <= len(w); l++ {
-			subwords = append(subwords, w[i:i+l])
-		}
-	}
-	return
-}
-
 func processSubwords() {
 	subwordMatrixRows = vocabSize
 
@@ -293,7 +591,7 @@ func processSubwords() {
 				if sw == wrappedWord {
 					continue // word already has own vector
 				}
-				w.subwords = append(w.subwords, subwordIdx(sw, vocabSize, idxUint(buckets)))
+				w.subwords = append(w.subwords, embedding.SubwordIdx(sw, vocabSize, idxUint(buckets)))
 			}
 		}
 		if foundSubwords != vocabSize {
@@ -302,11 +600,3 @@ func processSubwords() {
 		logln(infoLogLevel, "found %d subwords", foundSubwords)
 	}
 }
-
-func subwordIdx(sw string, vocabSize, buckets idxUint) idxUint {
-	h := fnv.New32()
-	_, err := h.Write([]byte(sw))
-	check(err)
-	hash := h.Sum32() % buckets
-	return vocabSize + hash
-}